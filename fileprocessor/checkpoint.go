@@ -0,0 +1,86 @@
+package fileprocessor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint is the progress of a run, as stored in Config.CheckpointPath
+type checkpoint struct {
+	//Processed is the number of lines read that are written to the output files, counted from the first line of the
+	//first input file. Every line before it is written, the lines after it may not be.
+	Processed int `json:"processed"`
+	//LineNumber is the input line number of the last line counted in Processed
+	LineNumber int `json:"line_number"`
+}
+
+// readCheckpoint reads the checkpoint at path. A missing file is an empty checkpoint so the first run can resume too.
+func readCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("error reading checkpoint file: %w", err)
+	}
+	if err := json.Unmarshal(content, &cp); err != nil {
+		return cp, fmt.Errorf("error decoding checkpoint file %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint writes cp to a temporary file next to path and renames it over path, so a crash never leaves a
+// partially written checkpoint
+func writeCheckpoint(path string, cp checkpoint) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint file: %w", err)
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing checkpoint file: %w", err)
+	}
+	return nil
+}
+
+// markWritten records that record is written, moving the checkpoint forward once all the lines before it are
+// written too
+func (p *fileProcessor) markWritten(record result) {
+	if p.config.CheckpointPath == "" {
+		return
+	}
+	p.written[record.Input.index] = record.Input.LineNumber
+	for {
+		lineNumber, ok := p.written[p.checkpoint.Processed]
+		if !ok {
+			return
+		}
+		delete(p.written, p.checkpoint.Processed)
+		p.checkpoint.Processed++
+		p.checkpoint.LineNumber = lineNumber
+	}
+}
+
+// saveCheckpoint writes the current checkpoint, it must only be called once the output files are flushed
+func (p *fileProcessor) saveCheckpoint() error {
+	if p.config.CheckpointPath == "" {
+		return nil
+	}
+	return writeCheckpoint(p.config.CheckpointPath, p.checkpoint)
+}
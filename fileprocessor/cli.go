@@ -0,0 +1,450 @@
+package fileprocessor
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Process parses the program arguments and processes the input file. Any error is fatal.
+func Process(processor Processor) {
+	cfg, err := parseFlags(os.Args[1:], processor != nil, nil)
+	exitOnArgsError(err)
+
+	exitOnError(ProcessE(processor, cfg))
+}
+
+// The exit codes of Process and Main, so a script running the program can tell why it failed
+const (
+	//ExitOK is returned by a successful run
+	ExitOK = 0
+	//ExitError is returned for any error without a more specific code
+	ExitError = 1
+	//ExitArgs is returned for a missing or invalid argument, the same code flag.Parse uses, and for a run stopped by
+	//ErrInvalidConfig
+	ExitArgs = 2
+	//ExitIO is returned when a file cannot be opened, created, read or written
+	ExitIO = 3
+	//ExitData is returned when the input data is invalid, or when lines failed with Config.FailOnAnyError
+	ExitData = 4
+)
+
+// ExitCode returns the exit code matching err, the error returned by a run
+func ExitCode(err error) int {
+	var parseErr *csv.ParseError
+	var pathErr *fs.PathError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrInvalidConfig):
+		return ExitArgs
+	case errors.Is(err, ErrLinesFailed), errors.Is(err, ErrInvalidInput), errors.As(err, &parseErr):
+		return ExitData
+	case errors.Is(err, ErrInputOpen), errors.Is(err, ErrOutputCreate), errors.Is(err, ErrHeaderRead),
+		errors.As(err, &pathErr):
+		return ExitIO
+	}
+	return ExitError
+}
+
+// exitOnArgsError exits when err, returned while reading the program arguments, is not nil. The version is printed
+// when it was asked for.
+func exitOnArgsError(err error) {
+	if errors.Is(err, errVersion) {
+		fmt.Println(versionInfo())
+		exit(ExitOK)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(ExitArgs)
+	}
+}
+
+// exitOnError exits with the ExitCode of err, returned by a run, when it is not nil
+func exitOnError(err error) {
+	if err != nil {
+		log.Print(err)
+		exit(ExitCode(err))
+	}
+}
+
+// exit ends the program with code. Every exit of Process and Main goes through it, but for the flag package exiting
+// with ExitArgs on an unknown argument
+func exit(code int) {
+	os.Exit(code)
+}
+
+// tokenEnv is the environment variable holding the access token when it is not an argument
+const tokenEnv = "PROC_TOKEN"
+
+// errVersion is returned by parseFlags when the -version argument asks to print the version instead of processing
+var errVersion = errors.New("version requested")
+
+// parseFlags builds a Config from the program arguments. It returns an error when a required argument is missing or
+// an argument value is invalid. The required -processor argument is read into processorName unless it is nil.
+func parseFlags(args []string, tokenRequired bool, processorName *string) (Config, error) {
+	var inputPathArg = "inputPath"
+	var outputPathArg = "outputPath"
+	var tokenArg = "token"
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	inputPathPtr := flags.String(inputPathArg, "default input", "input file path, a comma separated list of paths or glob patterns")
+	outputPathPtr := flags.String(outputPathArg, "default output", "output file path")
+	outputShards := flags.Int("outputShards", 0, "number of output files the successful lines are split into by identifier, a single file by default")
+	failurePathPtr := flags.String("failurePath", "", "failures file path, failures.csv next to the output file by default")
+	skippedPathPtr := flags.String("skippedPath", "", "file path of the skipped lines, none by default")
+	writeErrorPath := flags.String("writeErrorPath", "", "file path of the lines that could not be written to their file, none by default")
+	summaryPathPtr := flags.String("summaryPath", "", "json summary file path, none by default")
+	routinesNumberPtr := flags.Int("threads", 0, "number of parallel executions, the number of usable CPUs by default")
+	workerRampUp := flags.Duration("workerRampUp", 0, "time over which the start of the workers is spread, 0 to start them at once")
+	readConcurrency := flags.Int("readConcurrency", 1, "number of readers of a single large input file, its csv lines must not hold quoted line breaks")
+	hasHeaderPtr := flags.Bool("hasHeader", true, "indicates if the input file has a header or not, true by default")
+	duplicateColumns := flags.String("duplicateColumns", AllowDuplicateColumns.String(), "allow, reject or rename the header columns with the same name")
+	normalizeHeaders := flags.Bool("normalizeHeaders", false, "trims and lowercases the header names handed to the processor, spaces become underscores")
+	writeOutputHeader := flags.Bool("writeOutputHeader", true, "writes a header to the output file, the input header by default")
+	skipRows := flags.Int("skipRows", 0, "number of leading lines of the input discarded before the header")
+	headerInEveryFile := flags.Bool("headerInEveryFile", false, "indicates if every input file has a header, not only the first one")
+	token := flags.String(tokenArg, "", "access token, read from -tokenFile or $"+tokenEnv+" when empty")
+	tokenFile := flags.String("tokenFile", "", "file holding the access token, so it is not in the shell history")
+	settings := make(settingsFlag)
+	flags.Var(settings, "set", "key=value setting handed to a configurable processor, can be repeated")
+	failureLineNumbers := flags.Bool("failureLineNumbers", false, "adds the input line number as the first column of the failures file")
+	failureDurations := flags.Bool("failureDurations", false, "adds the time spent processing the line as the last column of the failures file")
+	slowestLines := flags.Int("slowestLines", 0, "number of slowest lines logged at the end of the run, 0 for none")
+	showDescription := flags.Bool("showDescription", false, "is description shown")
+	combinedOutput := flags.Bool("combinedOutput", false, "writes the failed lines to the output file with a status column instead of the failures file")
+	outputHeader := flags.String("outputHeader", "", "comma separated column names of the output file header, the input header by default")
+	outputColumns := flags.String("outputColumns", "", "comma separated positions, from 0, of the columns written to the output and failures files, all by default")
+	enforceColumnCount := flags.Bool("enforceColumnCount", false, "writes the output lines whose number of columns differs from the header to the failures file")
+	batchSize := flags.Int("batchSize", defaultBatchSize, "maximum number of lines processed at once by a batch processor")
+	inputBuffer := flags.Int("inputBuffer", defaultBufferSize, "number of lines read ahead of the workers")
+	readBufferSize := flags.Int("readBufferSize", defaultReadBufferSize, "size in bytes of the buffer the input files are read through")
+	resultBuffer := flags.Int("resultBuffer", defaultBufferSize, "number of processed lines waiting to be written")
+	maxInFlight := flags.Int("maxInFlight", 0, "maximum number of lines read and not written yet, 0 for no limit but the buffers")
+	maxDuration := flags.Duration("maxDuration", 0, "stops the run once that time elapses, keeping the lines already processed, 0 for no limit")
+	shutdownTimeout := flags.Duration("shutdownTimeout", 0, "longest wait for the workers once the run is interrupted, 0 for no limit")
+	processTimeout := flags.Duration("processTimeout", 0, "longest time a line can be processed before failing, 0 for no limit")
+	maxRetries := flags.Int("maxRetries", 0, "number of retries of a line whose processing fails")
+	retryBackoff := flags.Duration("retryBackoff", time.Second, "wait before the first retry, doubled on each retry")
+	failOnError := flags.Bool("failOnError", false, "exits with code 4 once the run is over when any line failed")
+	maxFailures := flags.Int64("maxFailures", 0, "aborts the run once more lines failed, 0 for no limit")
+	maxFailureRatio := flags.Float64("maxFailureRatio", 0, "aborts the run once that fraction of the lines failed, 0.05 for 5%, 0 for no limit")
+	deduplicate := flags.Bool("deduplicate", false, "skips the lines whose identifier was already read")
+	filterPolicy := flags.String("filterPolicy", SkipFiltered.String(), "skip to write the lines rejected by the filter nowhere, or pass to write them to the output file as they are")
+	dedupePolicy := flags.String("dedupePolicy", KeepDuplicates.String(), "keep, dropFailures to leave out the failures of the identifiers already written as successes, or dropSuccesses for the opposite")
+	completedPath := flags.String("completedPath", "", "file listing the identifiers already completed, skipped and extended with the new successes, none by default")
+	checkpointPath := flags.String("checkpointPath", "", "file where the progress is saved to resume the run, none by default")
+	resume := flags.Bool("resume", false, "skips the lines already written according to the checkpoint file")
+	maxRows := flags.Int("maxRows", 0, "maximum number of input lines read, 0 for no limit")
+	appendOutput := flags.Bool("append", false, "adds the lines to the existing output files instead of replacing them")
+	atomicOutput := flags.Bool("atomicOutput", false, "replaces the output files only once the run succeeds")
+	dryRun := flags.Bool("dryRun", false, "validates the input lines without processing them")
+	rateLimit := flags.Float64("rateLimit", 0, "maximum number of process calls per second, 0 for no limit")
+	skipInvalid := flags.Bool("skipInvalid", false, "writes the invalid lines to the failures file instead of stopping")
+	compressed := flags.Bool("compressed", false, "reads and writes gzip files, implied by the .gz extension")
+	lazyQuotes := flags.Bool("lazyQuotes", false, "accepts misplaced quotes in the input fields")
+	trimLeadingSpace := flags.Bool("trimLeadingSpace", false, "removes the spaces at the start of the input fields")
+	minColumns := flags.Int("minColumns", 0, "least number of fields of an input line, shorter lines are invalid, no minimum by default")
+	expectedColumns := flags.Int("expectedColumns", 0, "hint of the number of fields of an input line, to allocate the failures rows once, no line is rejected for it")
+	columnRules := make(columnRulesFlag)
+	flags.Var(columnRules, "columnRule", "column=rules check of a column, by name or position from 0, the rules being a comma separated list of required, int, float and a last regex:pattern, can be repeated")
+	allowRaggedRows := flags.Bool("allowRaggedRows", false, "accepts input lines with a different number of fields than the first one")
+	delimiter := flags.String("delimiter", "", "field delimiter, \\t for tab, found from the input extension by default")
+	comment := flags.String("comment", "", "character starting the input lines to skip, such as #, none by default")
+	recordSeparator := flags.String("recordSeparator", "", `separator of the JSON Lines output records, with Go escapes such as \x00, a line break by default`)
+	useCRLF := flags.Bool("useCRLF", false, "ends the output lines with \\r\\n")
+	quoteAll := flags.Bool("quoteAll", false, "quotes every field of the output files")
+	inputEncoding := flags.String("encoding", "", "character encoding of the input files such as windows-1252, utf-8 by default")
+	format := flags.String("format", CSV.String(), "format of the input and output files, csv or jsonl")
+	flushEvery := flags.Int("flushEvery", defaultFlushEvery, "number of processed lines between flushes of the output files")
+	quiet := flags.Bool("quiet", false, "only prints the final totals and the errors")
+	verbose := flags.Bool("verbose", false, "also prints every line with its processing time and error")
+	logFormat := flags.String("logFormat", TextLog.String(), "format of the log messages, text or json")
+	progressEvery := flags.Int("progressEvery", 1, "prints the progress every n processed lines, 0 to disable it")
+	metricsAddr := flags.String("metricsAddr", "", "address of an HTTP server serving the live counts on /stats, such as localhost:8080")
+	writeConcurrency := flags.Int("writeConcurrency", 1, "number of goroutines writing the processed lines, in no particular order")
+	preserveOrder := flags.Bool("preserveOrder", false, "writes the output lines in the input order")
+	reorderWindow := flags.Int("reorderWindow", 0, "maximum number of lines held waiting for a late line with preserveOrder, 0 for no limit")
+	reorderPolicy := flags.String("reorderPolicy", BlockReorder.String(), "block to wait for the late line once reorderWindow lines are held, or flush to write them out of order")
+	version := flags.Bool("version", false, "prints the version and exits")
+
+	requiredArguments := []string{inputPathArg, outputPathArg}
+	if tokenRequired {
+		requiredArguments = append(requiredArguments, tokenArg)
+	}
+	if processorName != nil {
+		flags.StringVar(processorName, "processor", "", "name of the registered processor to run")
+		requiredArguments = append(requiredArguments, "processor")
+	}
+	flags.Parse(args)
+	if *version {
+		return Config{}, errVersion
+	}
+
+	seen := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { seen[f.Name] = true })
+	resolvedToken, err := resolveToken(*token, *tokenFile)
+	if err != nil {
+		return Config{}, err
+	}
+	if resolvedToken != "" {
+		seen[tokenArg] = true
+	}
+	if err := validateArgs(seen, requiredArguments); err != nil {
+		return Config{}, err
+	}
+
+	delimiterRune, err := parseDelimiter(*delimiter)
+	if err != nil {
+		return Config{}, err
+	}
+	// the comment cannot be the delimiter found from the input path either
+	commentRune, err := parseComment(*comment, resolveDelimiter(delimiterRune, *inputPathPtr))
+	if err != nil {
+		return Config{}, err
+	}
+	separator, err := parseRecordSeparator(*recordSeparator)
+	if err != nil {
+		return Config{}, err
+	}
+	fileFormat, err := parseFormat(*format)
+	if err != nil {
+		return Config{}, err
+	}
+	fileEncoding, err := parseEncoding(*inputEncoding)
+	if err != nil {
+		return Config{}, err
+	}
+	dedupe, err := parseDedupePolicy(*dedupePolicy)
+	if err != nil {
+		return Config{}, err
+	}
+	filter, err := parseFilterPolicy(*filterPolicy)
+	if err != nil {
+		return Config{}, err
+	}
+	columnPolicy, err := parseDuplicateColumnPolicy(*duplicateColumns)
+	if err != nil {
+		return Config{}, err
+	}
+	reorder, err := parseReorderPolicy(*reorderPolicy)
+	if err != nil {
+		return Config{}, err
+	}
+	messageFormat, err := parseLogFormat(*logFormat)
+	if err != nil {
+		return Config{}, err
+	}
+	logLevel := NormalLog
+	switch {
+	case *quiet && *verbose:
+		return Config{}, errors.New("the -quiet and -verbose arguments cannot be used together")
+	case *quiet:
+		logLevel = QuietLog
+	case *verbose:
+		logLevel = VerboseLog
+	}
+
+	var outputHeaderColumns []string
+	if *outputHeader != "" {
+		outputHeaderColumns = strings.Split(*outputHeader, ",")
+	}
+	columns, err := parseColumns(*outputColumns)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// the messages must not be mixed with the output lines
+	logOutput := os.Stdout
+	if *outputPathPtr == stdStream || *failurePathPtr == stdStream {
+		logOutput = os.Stderr
+	}
+
+	return Config{
+		InputPath:          *inputPathPtr,
+		OutputPath:         *outputPathPtr,
+		OutputShards:       *outputShards,
+		FailurePath:        *failurePathPtr,
+		SkippedPath:        *skippedPathPtr,
+		WriteErrorPath:     *writeErrorPath,
+		SummaryPath:        *summaryPathPtr,
+		Token:              resolvedToken,
+		Settings:           settings,
+		Threads:            *routinesNumberPtr,
+		WorkerRampUp:       *workerRampUp,
+		ReadConcurrency:    *readConcurrency,
+		HasHeader:          *hasHeaderPtr,
+		OmitOutputHeader:   !*writeOutputHeader,
+		NormalizeHeaders:   *normalizeHeaders,
+		DuplicateColumns:   columnPolicy,
+		SkipRows:           *skipRows,
+		HeaderInEveryFile:  *headerInEveryFile,
+		ShowDescription:    *showDescription,
+		CombinedOutput:     *combinedOutput,
+		FailureLineNumbers: *failureLineNumbers,
+		FailureDurations:   *failureDurations,
+		SlowestLines:       *slowestLines,
+		OutputHeader:       outputHeaderColumns,
+		OutputColumns:      columns,
+		EnforceColumnCount: *enforceColumnCount,
+		BatchSize:          *batchSize,
+		InputBuffer:        *inputBuffer,
+		ReadBufferSize:     *readBufferSize,
+		ResultBuffer:       *resultBuffer,
+		MaxInFlight:        *maxInFlight,
+		ProcessTimeout:     *processTimeout,
+		ShutdownTimeout:    *shutdownTimeout,
+		MaxDuration:        *maxDuration,
+		MaxRetries:         *maxRetries,
+		RetryBackoff:       *retryBackoff,
+		FailOnAnyError:     *failOnError,
+		MaxFailures:        *maxFailures,
+		MaxFailureRatio:    *maxFailureRatio,
+		Deduplicate:        *deduplicate,
+		FilterPolicy:       filter,
+		DedupePolicy:       dedupe,
+		CompletedPath:      *completedPath,
+		CheckpointPath:     *checkpointPath,
+		Resume:             *resume,
+		MaxRows:            *maxRows,
+		Append:             *appendOutput,
+		AtomicOutput:       *atomicOutput,
+		DryRun:             *dryRun,
+		RateLimit:          *rateLimit,
+		SkipInvalid:        *skipInvalid,
+		Compressed:         *compressed,
+		LazyQuotes:         *lazyQuotes,
+		TrimLeadingSpace:   *trimLeadingSpace,
+		MinColumns:         *minColumns,
+		ExpectedColumns:    *expectedColumns,
+		ColumnRules:        columnRules,
+		AllowRaggedRows:    *allowRaggedRows,
+		Delimiter:          delimiterRune,
+		Comment:            commentRune,
+		RecordSeparator:    separator,
+		Encoding:           fileEncoding,
+		UseCRLF:            *useCRLF,
+		QuoteAll:           *quoteAll,
+		Format:             fileFormat,
+		FlushEvery:         *flushEvery,
+		Logger:             log.New(logOutput, "", 0),
+		LogLevel:           logLevel,
+		LogFormat:          messageFormat,
+		ProgressEvery:      *progressEvery,
+		MetricsAddr:        *metricsAddr,
+		WriteConcurrency:   *writeConcurrency,
+		PreserveOrder:      *preserveOrder,
+		ReorderWindow:      *reorderWindow,
+		ReorderPolicy:      reorder,
+	}, nil
+}
+
+// resolveToken returns the -token argument. When it is empty the token is read from the tokenFile, or else from the
+// PROC_TOKEN environment variable.
+func resolveToken(token, tokenFile string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading -tokenFile: %w", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return os.Getenv(tokenEnv), nil
+}
+
+// validateArgs returns an error naming the required arguments that are not in seen
+func validateArgs(seen map[string]bool, required []string) error {
+	var missing []string
+	for _, req := range required {
+		if !seen[req] {
+			missing = append(missing, "-"+req)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required arguments: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// settingsFlag collects the key=value pairs of a repeated argument
+type settingsFlag map[string]string
+
+func (s settingsFlag) String() string {
+	pairs := make([]string, 0, len(s))
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (s settingsFlag) Set(pair string) error {
+	key, value, found := strings.Cut(pair, "=")
+	if !found || key == "" {
+		return fmt.Errorf("invalid setting %q, it must be key=value", pair)
+	}
+	s[key] = value
+	return nil
+}
+
+// parseColumns converts the comma separated column positions of the outputColumns argument, nil when it is empty
+func parseColumns(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var columns []int
+	for _, field := range strings.Split(value, ",") {
+		column, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || column < 0 {
+			return nil, fmt.Errorf("invalid -outputColumns argument %q, it must be a comma separated list of positions from 0", value)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// parseDelimiter converts the delimiter argument into a rune. The \t escape is accepted for tab separated files. It is
+// zero when empty, so the delimiter is found from the input path.
+func parseDelimiter(value string) (rune, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if value == `\t` {
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("invalid -delimiter argument %q, it must be a single character", value)
+	}
+	return runes[0], nil
+}
+
+// parseRecordSeparator converts the recordSeparator argument into the string it stands for, its Go escapes such as
+// \x00 or \x1e replaced
+func parseRecordSeparator(value string) (string, error) {
+	separator, err := strconv.Unquote(`"` + value + `"`)
+	if err != nil {
+		return "", fmt.Errorf("invalid -recordSeparator argument %q: %w", value, err)
+	}
+	return separator, nil
+}
+
+// parseComment converts the comment argument into a rune, zero when it is empty. It must differ from delimiter.
+func parseComment(value string, delimiter rune) (rune, error) {
+	if value == "" {
+		return 0, nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == delimiter {
+		return 0, fmt.Errorf("invalid -comment argument %q, it must be a single character other than the delimiter", value)
+	}
+	return runes[0], nil
+}
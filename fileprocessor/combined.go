@@ -0,0 +1,56 @@
+package fileprocessor
+
+// The values of the status column of a Config.CombinedOutput file
+const (
+	successStatus  = "success"
+	failureStatus  = "failure"
+	filteredStatus = "filtered"
+)
+
+// combinedHeader returns the output header of a Config.CombinedOutput file: header followed by the status column and,
+// with ShowDescription, the error_description column
+func (p *fileProcessor) combinedHeader(header []string) []string {
+	header = appendField(header, "status")
+	if p.config.ShowDescription {
+		header = appendField(header, "error_description")
+	}
+	return header
+}
+
+// combinedLine returns line followed by status and, with ShowDescription, the description of err. When every column
+// is written the lines shorter than the output header are padded so the status always lands in its column.
+func (p *fileProcessor) combinedLine(line []string, status string, err error) []string {
+	width := len(line)
+	if width < p.outputWidth && p.config.OutputColumns == nil {
+		width = p.outputWidth
+	}
+	// the status goes to a fresh slice, line may be shared with the Processor
+	combined := make([]string, width, p.rowCapacity(width, 2))
+	copy(combined, line)
+	combined = append(combined, status)
+	if p.config.ShowDescription {
+		combined = append(combined, errorDescription(err))
+	}
+	return combined
+}
+
+// combinedRecord returns record with the status key and, with ShowDescription, the error_description key. It returns
+// nil for a csv line.
+func (p *fileProcessor) combinedRecord(record map[string]interface{}, status string, err error) map[string]interface{} {
+	if record == nil {
+		return nil
+	}
+	fields := map[string]interface{}{"status": status}
+	if p.config.ShowDescription {
+		fields["error_description"] = errorDescription(err)
+	}
+	return withFields(record, fields)
+}
+
+// errorDescription returns the description of err, empty when it is nil
+func errorDescription(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
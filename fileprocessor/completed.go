@@ -0,0 +1,61 @@
+package fileprocessor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readCompleted reads the identifiers of the Config.CompletedPath file at path, one per line. A missing file holds no
+// identifier, so the first run can use the same path as the following ones.
+func readCompleted(path string) (map[uint64]struct{}, error) {
+	completed := make(map[uint64]struct{})
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return completed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening completed identifiers file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identifier on line %d of completed identifiers file %s: %w", lineNumber,
+				path, err)
+		}
+		completed[id] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading completed identifiers file: %w", err)
+	}
+	return completed, nil
+}
+
+// isCompleted tells if the identifier of input was completed by a previous run. The identifiers are only read, so
+// the readers of several ranges can look them up at once.
+func (p *fileProcessor) isCompleted(input Input) bool {
+	_, id := p.processor.GetIdentifier(input)
+	_, ok := p.completed[id]
+	return ok
+}
+
+// markCompleted adds the identifier of the successful line input to the Config.CompletedPath file
+func (p *fileProcessor) markCompleted(input Input) {
+	if p.completedWriter == nil {
+		return
+	}
+	_, id := p.processor.GetIdentifier(input)
+	if err := p.completedWriter.Write([]string{strconv.FormatUint(id, 10)}, nil); err != nil {
+		p.logger.Printf("error writing identifier %d to completed identifiers file: %v", id, err)
+	}
+}
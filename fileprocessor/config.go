@@ -0,0 +1,318 @@
+package fileprocessor
+
+import (
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+const (
+	defaultFailurePath      = "failures.csv"
+	defaultTSVFailurePath   = "failures.tsv"
+	defaultJSONLFailurePath = "failures.jsonl"
+	defaultDelimiter        = ','
+	defaultBatchSize        = 100
+	defaultFlushEvery       = 100
+	defaultBufferSize       = 100
+	defaultReadBufferSize   = 4096
+	//minFailureRatioLines is the number of lines written before Config.MaxFailureRatio is checked
+	minFailureRatioLines = 100
+)
+
+// Config holds the parameters of a processing run
+type Config struct {
+	//InputPath is the path of the csv file to be processed. It can also be a comma separated list of paths or glob
+	//patterns, the files are then read in order as a single input
+	InputPath string
+	//OutputPath is the path of the csv file where the successful lines are written
+	OutputPath string
+	//OutputShards splits the successful lines among that many output files named after the OutputPath, such as
+	//output-shard0.csv, output-shard1.csv... A line goes to the shard of its ShardKey modulo OutputShards, an invalid
+	//line written to the output by CombinedOutput to the first shard. There is a single output file when not above 1
+	OutputShards int
+	//ShardKey returns the key choosing the output shard of a successful line, the identifier returned by
+	//Processor.GetIdentifier when nil
+	ShardKey func(Input) uint64
+	//FailurePath is the path of the file where the failed lines are written. When empty it is failures.csv in
+	//the directory of the OutputPath, failures.tsv when the Delimiter is a tab, failures.jsonl for the JSONL Format,
+	//with the .gz extension when the output is compressed
+	FailurePath string
+	//SkippedPath is the path of the file where the lines whose Output is Skipped are written, none when empty
+	SkippedPath string
+	//WriteErrorPath is the path of the file receiving the lines that could not be written to their file, along with
+	//the write error, none when empty
+	WriteErrorPath string
+	//SummaryPath is the path of the json file where the Summary is written at the end of the run, none when empty
+	SummaryPath string
+	//Token is the access token handed to Processor.SetToken
+	Token string
+	//Settings are handed to Processor.Configure when the Processor is Configurable
+	Settings map[string]string
+	//Threads is the number of parallel executions, GOMAXPROCS when not positive
+	Threads int
+	//WorkerRampUp spreads the start of the workers over that time, each one waiting its turn plus a random jitter, so
+	//they do not all hit a shared resource at once. All the workers start at once when not positive
+	WorkerRampUp time.Duration
+	//HasHeader indicates if the input file has a header or not. The output file gets a header too unless
+	//OmitOutputHeader is set
+	HasHeader bool
+	//SkipRows is the number of leading lines of the input discarded before the header, or before the first line to
+	//process without a header, such as a title and a blank line written by a report tool. They are discarded from
+	//every input file when HeaderInEveryFile is set, from the first one otherwise. None when not positive
+	SkipRows int
+	//OmitOutputHeader writes the output file without a header, even when the input has one or OutputHeader is set.
+	//The failures and skipped files keep the input header
+	OmitOutputHeader bool
+	//NormalizeHeaders hands the header to a HeaderAware Processor with every column name normalized by
+	//NormalizeColumn, so the columns can be looked up by a canonical name. The header written to the files is kept as
+	//it is
+	NormalizeHeaders bool
+	//DuplicateColumns tells what to do when several columns of the input header have the same name, once normalized
+	//with NormalizeHeaders: the run goes on with the header as it is by default, RejectDuplicateColumns stops it and
+	//RenameDuplicateColumns renames the repeated names handed to a HeaderAware Processor. The header written to the
+	//files is kept as it is
+	DuplicateColumns DuplicateColumnPolicy
+	//ReadConcurrency is the number of readers of a single input file, each one reading its own byte range of the
+	//file. The csv lines must not hold quoted line breaks. The file is read sequentially when not above 1, and when
+	//it cannot be split: the standard input, a compressed file, several input files, another Encoding, SkipRows, or
+	//when the lines must be read in order for PreserveOrder, CheckpointPath, Deduplicate, MaxRows or DryRun
+	ReadConcurrency int
+	//HeaderInEveryFile indicates that every input file starts with the header, not only the first one
+	HeaderInEveryFile bool
+	//ShowDescription indicates if the error description is added to the failed lines
+	ShowDescription bool
+	//CombinedOutput writes the failed lines to the output file along with the successful ones, instead of the
+	//failures file, which is not created. Every line gets a status column, success or failure, and with
+	//ShowDescription an error_description column
+	CombinedOutput bool
+	//FailureLineNumbers adds the line number of the failed lines in the input file, from 1, as the first column of the
+	//failures file, named line_number in its header
+	FailureLineNumbers bool
+	//FailureDurations adds the time spent processing the failed lines, in milliseconds, as the last column of the
+	//failures file, named process_ms in its header. The invalid lines are not processed and get 0
+	FailureDurations bool
+	//SlowestLines is the number of slowest lines processed whose line number and duration are logged at the end of
+	//the run and returned in Summary.Slowest. None when not positive
+	SlowestLines int
+	//EnforceColumnCount writes the successful lines whose output has not as many columns as the output header to
+	//the failures file instead of the output file. It has no effect without a header
+	EnforceColumnCount bool
+	//OutputHeader is written as the first line of the csv output file instead of the input header, even when the
+	//input has no header
+	OutputHeader []string
+	//HeaderTransform changes the header written to the csv output and failures files, such as to rename its columns.
+	//It gets the header about to be written, the input header or OutputHeader with only the OutputColumns, and is
+	//called before the status and error_description columns are added. The header is written as it is when nil
+	HeaderTransform func([]string) []string
+	//OutputColumns are the positions, from 0, of the columns kept in the csv output and failures files, headers
+	//included, in the order they are written. The successful lines are projected once processed, the failures keep
+	//the input columns at those positions. Every column is written when nil
+	OutputColumns []int
+	//BatchSize is the maximum number of lines handed at once to a BatchProcessor, 100 when not positive
+	BatchSize int
+	//InputBuffer is the number of lines read ahead of the workers, 100 when not positive
+	InputBuffer int
+	//ReadBufferSize is the size in bytes of the buffer the input files are read through, 4096 when not positive.
+	//A larger buffer makes fewer reads, which helps on high latency storage such as network volumes
+	ReadBufferSize int
+	//MaxInFlight is the maximum number of lines read and not written yet, whatever the buffers. The reading waits
+	//while that many lines are held, so the memory stays bounded with a slow Processor. It is at least Threads times
+	//BatchSize for a BatchProcessor. No limit but the buffers when not positive
+	MaxInFlight int
+	//Pause pauses the run when it receives true and resumes it when it receives false or is closed. A paused run
+	//keeps reading and writing the lines already processed, but the workers take no new line until it is resumed,
+	//the lines they hold being processed. It is not read when nil
+	Pause <-chan bool
+	//ResultBuffer is the number of processed lines waiting to be written, 100 when not positive
+	ResultBuffer int
+	//ProcessTimeout is the longest a Process or ProcessBatch call can take before its lines fail with a timeout
+	//error, no limit when not positive. The call that times out is not stopped, it goes on in the background
+	ProcessTimeout time.Duration
+	//ShutdownTimeout is the longest the run waits for the workers to finish the lines they hold once it is cancelled
+	//or aborted. Past that time the lines already processed are written and flushed, the others are given up and the
+	//Summary is Truncated. The run waits for every worker when not positive
+	ShutdownTimeout time.Duration
+	//MaxDuration stops the whole run once that time elapses, like a cancelled context: the lines already processed are
+	//written and the Summary tells how many lines were left Unprocessed. No limit when not positive
+	MaxDuration time.Duration
+	//MaxRetries is the number of times a line is processed again while its Output holds a retryable error
+	MaxRetries int
+	//RetryBackoff is the wait before the first retry, it doubles on each following retry
+	RetryBackoff time.Duration
+	//Retryable tells if an Output error is transient and worth a retry, every error is retried when nil
+	Retryable func(error) bool
+	//FailOnAnyError makes a run with failed lines return an error wrapping ErrLinesFailed once every line is written,
+	//so the program exits with ExitData, code 4. The invalid lines of a dry run count as failed lines
+	FailOnAnyError bool
+	//MaxFailures aborts the run once more lines than that failed, no limit when not positive
+	MaxFailures int64
+	//MaxFailureRatio aborts the run once the failed lines exceed that fraction of the lines written, 0.05 for 5%. It
+	//is checked once 100 lines are written, no limit when not positive
+	MaxFailureRatio float64
+	//FilterPolicy tells what becomes of the lines rejected by the Filter of the Processor, they are written nowhere
+	//by default
+	FilterPolicy FilterPolicy
+	//Deduplicate skips the lines whose identifier, as returned by Processor.GetIdentifier, was already read
+	Deduplicate bool
+	//DedupePolicy leaves out the successes or the failures whose identifier was already written to the other file.
+	//Only the lines of the current run are compared, the invalid lines are always written
+	DedupePolicy DedupePolicy
+	//CompletedPath is the path of a file listing the identifiers, as returned by Processor.GetIdentifier, of the lines
+	//completed by previous runs, one per line. The lines with one of those identifiers are skipped, and the identifier
+	//of every successful line is added to the file once the line is written, flushed along with the output files. A
+	//run can then be repeated without processing a successful line twice. None when empty
+	CompletedPath string
+	//CheckpointPath is the path of the file where the progress of the run is saved every FlushEvery lines, none when
+	//empty
+	CheckpointPath string
+	//Resume skips the lines already written according to the CheckpointPath file and appends the new lines to the
+	//output files
+	Resume bool
+	//MaxRows is the maximum number of lines read from the input, the header excluded, no limit when not positive
+	MaxRows int
+	//Append adds the lines to the output, failures and skipped files instead of truncating them. A file that already
+	//has content does not get a header again. It cannot be used along with AtomicOutput
+	Append bool
+	//AtomicOutput writes the output files under a temporary name and renames them to their paths once the run
+	//succeeds. A failed or cancelled run removes them, leaving the previous files untouched. It cannot be used along
+	//with a CheckpointPath
+	AtomicOutput bool
+	//DryRun only reads and validates the input lines, nothing is processed nor written
+	DryRun bool
+	//RateLimit is the maximum number of Process or ProcessBatch calls per second among all the workers, no limit
+	//when not positive
+	RateLimit float64
+	//SkipInvalid writes the lines that do not pass the validation to the failures file instead of stopping the run
+	SkipInvalid bool
+	//Compressed reads and writes gzip files even when their paths do not have the .gz extension
+	Compressed bool
+	//LazyQuotes accepts quotes inside unquoted fields and unescaped quotes inside quoted fields of the csv input
+	LazyQuotes bool
+	//TrimLeadingSpace removes the spaces at the start of every field of the csv input, even when the delimiter is a
+	//space
+	TrimLeadingSpace bool
+	//MinColumns is the least number of fields of a csv input line. A shorter line is invalid without being handed to
+	//Processor.Validate nor Process: it stops the run, or is written to the failures file with SkipInvalid. No minimum
+	//when not positive
+	MinColumns int
+	//ExpectedColumns is a hint of the number of fields of the csv input lines, used as the capacity of the rows written
+	//to the failures file and the CombinedOutput. No line is checked against it, MinColumns does that
+	ExpectedColumns int
+	//ColumnRules are the checks on the columns of every input line, keyed by the column name in the normalized header
+	//or its position from 0, or by the key of the JSON Lines objects. They are checked before Processor.Validate and
+	//a line breaking one is written to the failures file, SkipInvalid or not, with an error wrapping ErrColumnRule
+	ColumnRules map[string]ColumnRule
+	//AllowRaggedRows lets the csv input lines have a different number of fields than the first line. Otherwise such
+	//lines are invalid: they stop the run, or are written to the failures file with SkipInvalid
+	AllowRaggedRows bool
+	//Delimiter is the field delimiter of the input and output files. When zero it is found from the extension of the
+	//first InputPath: a tab for .tsv files, ',' otherwise
+	Delimiter rune
+	//Comment starts the csv input lines that are skipped, such as '#'. It must be at the start of the line, without
+	//leading spaces, and differ from the Delimiter. No line is a comment when zero
+	Comment rune
+	//Encoding is the character encoding of the input files, such as charmap.Windows1252, they are converted to UTF-8
+	//before being parsed. UTF-8 when nil. A leading byte order mark is always removed
+	Encoding encoding.Encoding
+	//UseCRLF ends the lines of the csv output files with \r\n instead of \n
+	UseCRLF bool
+	//QuoteAll quotes every field of the csv output files, not only the fields that need it
+	QuoteAll bool
+	//Encoder, when set, writes the successful lines to the output file in its own layout instead of the Format. The
+	//output file then gets no header. The failures and skipped files keep the Format. It cannot be used along with
+	//CombinedOutput, whose failures would be encoded as successes
+	Encoder Encoder
+	//RecordSeparator is written after every record of the output file written by the Encoder or in the JSONL Format,
+	//such as a null byte, instead of the line break. The Encoder must then not end its records with a line break.
+	//It does not apply to the csv files nor to the failures and skipped files
+	RecordSeparator string
+	//Format is the encoding of the input and output files, CSV by default. JSONL files have no header, so HasHeader
+	//is ignored and the Delimiter, OutputColumns and MinColumns are not used
+	Format Format
+	//OnError is called with every line whose processing fails and every line that cannot be written. It is called
+	//one line at a time, from a single goroutine unless WriteConcurrency is set.
+	OnError func(Input, error)
+	//OnSuccess is called with every successful line once it is written to the output file, and OnFailure with every
+	//failed line once it is written to the failures file. They are called from the goroutine that writes the files,
+	//one line at a time in the order the lines are written, so a slow callback slows the run down
+	OnSuccess func(Input, Output)
+	OnFailure func(Input, Output)
+	//FlushEvery is the number of processed lines between two flushes of the output files, 100 when not positive.
+	//A value of 1 flushes every line as soon as it is written.
+	FlushEvery int
+	//Logger receives the progress messages, nothing is printed when nil
+	Logger Logger
+	//LogLevel is the amount of messages sent to the Logger, QuietLog only sends the final totals and the errors while
+	//VerboseLog adds a message for every line. NormalLog when zero
+	LogLevel LogLevel
+	//LogFormat is the format of the messages sent to the Logger, JSONLog sends them as JSON objects. TextLog when zero
+	LogFormat LogFormat
+	//Metrics receives the measures of the run, none are taken when nil
+	Metrics Metrics
+	//MetricsAddr is the address, such as localhost:8080, of an HTTP server serving the live counts of the run as JSON
+	//on /stats. The server stops once the run is over. None is started when empty
+	MetricsAddr string
+	//ProgressEvery prints a progress line every ProgressEvery processed lines, none when not positive
+	ProgressEvery int
+	//WriteConcurrency is the number of goroutines writing the processed lines, so a slow disk does not hold the
+	//workers back. Each file is still written one line at a time, and so are the callbacks called, in no particular
+	//order. A single goroutine writes when not above 1, and always with PreserveOrder
+	WriteConcurrency int
+	//PreserveOrder writes the success, failure and skipped lines in the same order they have in the input file, so
+	//a deterministic Processor writes the same files on every run over the same input
+	PreserveOrder bool
+	//ReorderWindow is the maximum number of lines held with PreserveOrder while they wait for a late line. Once that
+	//many lines are held the reading waits for the late line, or with the FlushReorder ReorderPolicy the held lines
+	//are written out of order. With a BatchProcessor and BlockReorder it is raised to at least Threads times
+	//BatchSize, like MaxInFlight, so every worker can fill its batch. No limit but MaxInFlight when not positive
+	ReorderWindow int
+	//ReorderPolicy tells what happens once ReorderWindow lines are held, the reading waits by default
+	ReorderPolicy ReorderPolicy
+}
+
+// withDefaults returns a copy of c where the unset values are replaced by their defaults
+func (c Config) withDefaults() Config {
+	if c.Threads <= 0 {
+		c.Threads = runtime.GOMAXPROCS(0)
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.InputBuffer <= 0 {
+		c.InputBuffer = defaultBufferSize
+	}
+	if c.ResultBuffer <= 0 {
+		c.ResultBuffer = defaultBufferSize
+	}
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = defaultReadBufferSize
+	}
+	if c.FlushEvery <= 0 {
+		c.FlushEvery = defaultFlushEvery
+	}
+	if c.SkipRows < 0 {
+		c.SkipRows = 0
+	}
+	if c.Format == JSONL {
+		c.HasHeader = false
+		c.OutputColumns = nil
+		c.MinColumns = 0
+		c.ExpectedColumns = 0
+	}
+	c.Delimiter = resolveDelimiter(c.Delimiter, c.InputPath)
+	if c.FailurePath == "" {
+		failureFile := defaultFailurePath
+		if c.Format == JSONL {
+			failureFile = defaultJSONLFailurePath
+		} else if c.Delimiter == '\t' {
+			failureFile = defaultTSVFailurePath
+		}
+		if isCompressed(c.OutputPath, c.Compressed) {
+			failureFile += gzipExtension
+		}
+		c.FailurePath = filepath.Join(filepath.Dir(c.OutputPath), failureFile)
+	}
+	return c
+}
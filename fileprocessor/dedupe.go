@@ -0,0 +1,55 @@
+package fileprocessor
+
+import "fmt"
+
+// DedupePolicy tells which lines are left out when the same identifier is written both as a success and as a failure,
+// so the output and failures files together hold every identifier once
+type DedupePolicy int
+
+const (
+	//KeepDuplicates writes every line, an identifier can be in both files. It is the default
+	KeepDuplicates DedupePolicy = iota
+	//DropFailuresAfterSuccess leaves out the failures whose identifier was already written as a success
+	DropFailuresAfterSuccess
+	//DropSuccessesAfterFailure leaves out the successes whose identifier was already written as a failure
+	DropSuccessesAfterFailure
+)
+
+func (d DedupePolicy) String() string {
+	switch d {
+	case KeepDuplicates:
+		return "keep"
+	case DropFailuresAfterSuccess:
+		return "dropFailures"
+	case DropSuccessesAfterFailure:
+		return "dropSuccesses"
+	}
+	return fmt.Sprintf("DedupePolicy(%d)", int(d))
+}
+
+// parseDedupePolicy converts the dedupePolicy argument into a DedupePolicy
+func parseDedupePolicy(value string) (DedupePolicy, error) {
+	for _, policy := range []DedupePolicy{KeepDuplicates, DropFailuresAfterSuccess, DropSuccessesAfterFailure} {
+		if value == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -dedupePolicy argument %q, it must be keep, dropFailures or dropSuccesses", value)
+}
+
+// dropDuplicate tells if record must be left out according to Config.DedupePolicy. Otherwise the identifier of a
+// record of the kind that is kept is remembered. The invalid and skipped lines are never left out.
+func (p *fileProcessor) dropDuplicate(record result) bool {
+	policy := p.config.DedupePolicy
+	if policy == KeepDuplicates || record.invalid || record.filtered || record.Output.Skipped {
+		return false
+	}
+
+	_, id := p.processor.GetIdentifier(record.Input)
+	if record.Output.Success == (policy == DropFailuresAfterSuccess) {
+		p.writtenIdentifiers[id] = struct{}{}
+		return false
+	}
+	_, written := p.writtenIdentifiers[id]
+	return written
+}
@@ -0,0 +1,252 @@
+package fileprocessor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// stdStream is the path that stands for the standard input or output
+	stdStream = "-"
+	// gzipExtension is the extension of the files that are always compressed
+	gzipExtension = ".gz"
+	// tsvExtension is the extension of the tab separated files
+	tsvExtension = ".tsv"
+)
+
+// The errors returned by a run whose files cannot be opened or read. They wrap the underlying error, so errors.Is
+// also tells apart, for instance, os.ErrNotExist from os.ErrPermission.
+var (
+	//ErrInputOpen is returned when an input file cannot be opened
+	ErrInputOpen = errors.New("error opening input file")
+	//ErrOutputCreate is returned when the output, failures or skipped file cannot be created
+	ErrOutputCreate = errors.New("error creating output file")
+	//ErrHeaderRead is returned when the header of an input file cannot be read
+	ErrHeaderRead = errors.New("error reading header from input file")
+)
+
+// openInput opens the file at path for reading, "-" stands for the standard input. The content is decompressed when
+// compressed is true or the path has the .gz extension.
+func openInput(path string, compressed bool) (io.ReadCloser, error) {
+	var file io.ReadCloser = io.NopCloser(os.Stdin)
+	if path != stdStream {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+	if !isCompressed(path, compressed) {
+		return file, nil
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading gzip content: %w", err)
+	}
+	return gzipReadCloser{Reader: reader, file: file}, nil
+}
+
+// createOutput creates or truncates the file at path, "-" stands for the standard output. When appending, an existing
+// file keeps its content and the new lines are written after it. The content is compressed when compressed is true
+// or the path has the .gz extension, an appended gzip file then holds several gzip members.
+func createOutput(path string, compressed, appending bool) (io.WriteCloser, error) {
+	var file io.WriteCloser = nopWriteCloser{os.Stdout}
+	if path != stdStream {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appending {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path, flag, 0666)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+	return compressOutput(file, path, compressed), nil
+}
+
+// shardPath returns the path of the output shard number shard of the file at path, with -shard<n> added before its
+// extension: output.csv.gz becomes output-shard2.csv.gz
+func shardPath(path string, shard int) string {
+	base := strings.TrimSuffix(path, gzipExtension)
+	extension := filepath.Ext(base)
+	return fmt.Sprintf("%s-shard%d%s%s", strings.TrimSuffix(base, extension), shard, extension, path[len(base):])
+}
+
+// emptyFiles returns the paths whose file does not exist or holds nothing. The standard output is always empty.
+func emptyFiles(paths ...string) map[string]bool {
+	empty := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if path == stdStream || err != nil || info.Size() == 0 {
+			empty[path] = true
+		}
+	}
+	return empty
+}
+
+// createTempOutput is like createOutput but writes to a temporary file in the directory of path, which closeOutput
+// renames to path only when the run succeeds. The standard output is written directly.
+func createTempOutput(path string, compressed bool) (io.WriteCloser, error) {
+	if path == stdStream {
+		return createOutput(path, compressed, false)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return tempOutput{WriteCloser: compressOutput(file, path, compressed), tmpPath: file.Name(), path: path}, nil
+}
+
+// compressOutput compresses the content written to file when compressed is true or path has the .gz extension
+func compressOutput(file io.WriteCloser, path string, compressed bool) io.WriteCloser {
+	if !isCompressed(path, compressed) {
+		return file
+	}
+	return gzipWriteCloser{Writer: gzip.NewWriter(file), file: file}
+}
+
+// countLines returns the number of lines of the file at path. A last line without a line break is counted too.
+func countLines(path string, compressed bool) (int, error) {
+	file, err := openInput(path, compressed)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lines := 0
+	last := byte('\n')
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			lines += bytes.Count(buffer[:n], []byte{'\n'})
+			last = buffer[n-1]
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines, nil
+}
+
+// pathDelimiter returns the field delimiter of the file at path according to its extension, ignoring a trailing .gz:
+// tab for .tsv files, and the default delimiter for any other file
+func pathDelimiter(path string) rune {
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(path, gzipExtension)), tsvExtension) {
+		return '\t'
+	}
+	return defaultDelimiter
+}
+
+// resolveDelimiter returns delimiter, or the delimiter of the first file of inputPath when it is zero
+func resolveDelimiter(delimiter rune, inputPath string) rune {
+	if delimiter != 0 {
+		return delimiter
+	}
+	firstPath, _, _ := strings.Cut(inputPath, ",")
+	return pathDelimiter(strings.TrimSpace(firstPath))
+}
+
+// isCompressed indicates if the file at path holds gzip content
+func isCompressed(path string, compressed bool) bool {
+	return compressed || strings.HasSuffix(path, gzipExtension)
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying file
+type gzipReadCloser struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (r gzipReadCloser) Close() error {
+	err := r.Reader.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// gzipWriteCloser writes the gzip footer before closing the underlying file
+type gzipWriteCloser struct {
+	*gzip.Writer
+	file io.Closer
+}
+
+func (w gzipWriteCloser) Close() error {
+	err := w.Writer.Close()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// tempOutput is an output file written under a temporary name until the run succeeds
+type tempOutput struct {
+	io.WriteCloser
+	tmpPath string
+	path    string
+}
+
+// nopWriteCloser keeps the standard output open when the output is closed
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// flushWriter flushes w and stores its error in err unless err already holds one
+func flushWriter(w lineWriter, err *error) {
+	w.Flush()
+	if flushErr := w.Error(); flushErr != nil && *err == nil {
+		*err = fmt.Errorf("error flushing output: %w", flushErr)
+	}
+}
+
+// closeFile closes the file at path and stores its error in err unless err already holds one
+func closeFile(f io.Closer, path string, err *error) {
+	if closeErr := f.Close(); closeErr != nil && *err == nil {
+		*err = fmt.Errorf("error closing file %s: %w", path, closeErr)
+	}
+}
+
+// closeOutput closes the output file at path like closeFile. A temporary output is then renamed to path when err
+// holds no error, or removed so that the previous file at path is left untouched.
+func closeOutput(f io.WriteCloser, path string, err *error) {
+	closeFile(f, path, err)
+	temp, ok := f.(tempOutput)
+	if !ok {
+		return
+	}
+	if *err != nil {
+		os.Remove(temp.tmpPath)
+		return
+	}
+	if renameErr := os.Rename(temp.tmpPath, temp.path); renameErr != nil {
+		os.Remove(temp.tmpPath)
+		*err = fmt.Errorf("error replacing file %s: %w", path, renameErr)
+	}
+}
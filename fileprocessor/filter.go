@@ -0,0 +1,67 @@
+package fileprocessor
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Filter can be implemented by a Processor that only processes some of the lines, such as the ones whose status
+// column is active. ShouldProcess is called with every valid line before it is handed to the workers, the lines it
+// rejects are not processed and are handled according to Config.FilterPolicy.
+type Filter interface {
+	//ShouldProcess tells if the given line must be processed
+	ShouldProcess([]string) bool
+}
+
+// FilterPolicy tells what becomes of the lines rejected by a Filter
+type FilterPolicy int
+
+const (
+	//SkipFiltered writes the rejected lines nowhere, it is the default
+	SkipFiltered FilterPolicy = iota
+	//PassFiltered writes the rejected lines to the output file as they were read
+	PassFiltered
+)
+
+func (f FilterPolicy) String() string {
+	switch f {
+	case SkipFiltered:
+		return "skip"
+	case PassFiltered:
+		return "pass"
+	}
+	return fmt.Sprintf("FilterPolicy(%d)", int(f))
+}
+
+// parseFilterPolicy converts the filterPolicy argument into a FilterPolicy
+func parseFilterPolicy(value string) (FilterPolicy, error) {
+	for _, policy := range []FilterPolicy{SkipFiltered, PassFiltered} {
+		if value == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -filterPolicy argument %q, it must be skip or pass", value)
+}
+
+// isFiltered tells if the Filter of the Processor, if any, rejects line
+func (p *fileProcessor) isFiltered(line []string) bool {
+	filter, ok := p.processor.(Filter)
+	return ok && !filter.ShouldProcess(line)
+}
+
+// writeFiltered writes the line of record, rejected by the Filter, to the output file with PassFiltered
+func (p *fileProcessor) writeFiltered(record result) {
+	if p.config.FilterPolicy == PassFiltered {
+		writer := p.successWriters[p.shard(record)]
+		line, outRecord := p.project(record.Input.Line), record.Input.Record
+		if p.config.CombinedOutput {
+			line = p.combinedLine(line, filteredStatus, nil)
+			outRecord = p.combinedRecord(outRecord, filteredStatus, nil)
+		}
+		if err := p.writeLine(writer, line, outRecord); err != nil {
+			p.writeFailed(record, fmt.Errorf("error writing line to output file: %w", err))
+			return
+		}
+	}
+	atomic.AddInt64(&p.filteredCounter, 1)
+}
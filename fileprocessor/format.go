@@ -0,0 +1,391 @@
+package fileprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// utf8BOM is the byte order mark some programs, such as Excel, write at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Format is the encoding of the input and output files
+type Format int
+
+const (
+	//CSV files hold a line of delimited fields per record, it is the default
+	CSV Format = iota
+	//JSONL files hold a JSON object per line
+	JSONL
+)
+
+func (f Format) String() string {
+	switch f {
+	case CSV:
+		return "csv"
+	case JSONL:
+		return "jsonl"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// parseFormat converts the format argument into a Format
+func parseFormat(value string) (Format, error) {
+	for _, format := range []Format{CSV, JSONL} {
+		if value == format.String() {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -format argument %q, it must be csv or jsonl", value)
+}
+
+// Encoder turns the successful lines into the bytes of the output file, for a layout other than csv and JSON Lines
+// such as fixed width records. Its methods are called one line at a time.
+type Encoder interface {
+	//EncodeLine returns the bytes written to the output file for the given successful Output, a line break included.
+	//Its Line and Record are the ones written without an Encoder, the Input Line or Record when they are nil. Only the
+	//successes, and the filtered lines with PassFiltered, are encoded: an Encoder cannot be used with CombinedOutput
+	EncodeLine(Output) ([]byte, error)
+}
+
+// lineReader reads the lines of an input file
+type lineReader interface {
+	//Read returns the next line of the file, io.EOF when there is none left
+	Read() (Input, error)
+}
+
+// lineWriter writes the lines of an output file
+type lineWriter interface {
+	//Write writes line, or record when the file holds JSON objects
+	Write(line []string, record map[string]interface{}) error
+	//Flush writes any buffered line to the underlying writer
+	Flush()
+	//Error returns the error of a previous Write or Flush
+	Error() error
+}
+
+// offsetReader is a lineReader that tells how many bytes of its input it consumed
+type offsetReader interface {
+	lineReader
+	//InputOffset returns the number of bytes consumed by the lines read so far
+	InputOffset() int64
+}
+
+// newReader returns the reader of an input file in the configured Format, once its first skipRows lines are discarded
+func (p *fileProcessor) newReader(file io.Reader, skipRows int) (lineReader, error) {
+	input := p.decodeInput(file)
+	for skipped := 0; skipped < skipRows; skipped++ {
+		if _, err := input.ReadBytes('\n'); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return p.parseInput(input, skipRows+1), nil
+}
+
+// parseInput returns the reader of the lines of input in the configured Format. firstLine is the line number of the
+// first line of input in its file.
+func (p *fileProcessor) parseInput(input *bufio.Reader, firstLine int) offsetReader {
+	if p.config.Format == JSONL {
+		return &jsonReader{reader: input, lineNumber: firstLine - 1}
+	}
+	reader := csv.NewReader(input)
+	reader.Comma = p.config.Delimiter
+	reader.Comment = p.config.Comment
+	reader.LazyQuotes = p.config.LazyQuotes
+	reader.TrimLeadingSpace = p.config.TrimLeadingSpace
+	if p.config.AllowRaggedRows {
+		reader.FieldsPerRecord = -1
+	}
+	return csvReader{Reader: reader, lineOffset: firstLine - 1}
+}
+
+// decodeInput returns a reader of the content of file as UTF-8 without its leading byte order mark. The content is
+// converted from Config.Encoding when it is set.
+func (p *fileProcessor) decodeInput(file io.Reader) *bufio.Reader {
+	if p.config.Encoding != nil {
+		// a byte order mark overrides the configured encoding
+		decoder := transform.NewReader(file, unicode.BOMOverride(p.config.Encoding.NewDecoder()))
+		return bufio.NewReaderSize(decoder, p.config.ReadBufferSize)
+	}
+
+	reader := bufio.NewReaderSize(file, p.config.ReadBufferSize)
+	if start, _ := reader.Peek(len(utf8BOM)); bytes.Equal(start, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+	}
+	return reader
+}
+
+// parseEncoding returns the encoding with the given name, such as windows-1252 or iso-8859-1. Nil stands for UTF-8.
+func parseEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -encoding argument %q: %w", name, err)
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// newWriter returns the writer of an output file in the configured Format
+func (p *fileProcessor) newWriter(file io.Writer) lineWriter {
+	if p.config.Format == JSONL {
+		return &jsonWriter{writer: bufio.NewWriter(file), separator: "\n"}
+	}
+	if p.config.QuoteAll {
+		return &quoteAllWriter{writer: bufio.NewWriter(file), comma: p.config.Delimiter, useCRLF: p.config.UseCRLF}
+	}
+	writer := csv.NewWriter(file)
+	writer.Comma = p.config.Delimiter
+	writer.UseCRLF = p.config.UseCRLF
+	return csvWriter{writer}
+}
+
+// csvReader reads the delimited lines of a csv file
+type csvReader struct {
+	*csv.Reader
+	//lineOffset is the number of lines of the file before the first line read
+	lineOffset int
+}
+
+// Read also returns the line along with the csv.ErrFieldCount error of a line that does not have as many fields as
+// the first one
+func (r csvReader) Read() (Input, error) {
+	line, err := r.Reader.Read()
+	var parseErr *csv.ParseError
+	if r.lineOffset > 0 && errors.As(err, &parseErr) {
+		parseErr.StartLine += r.lineOffset
+		parseErr.Line += r.lineOffset
+	}
+	if line == nil {
+		return Input{}, err
+	}
+	lineNumber, _ := r.FieldPos(0)
+	return Input{Line: line, LineNumber: r.lineOffset + lineNumber}, err
+}
+
+// csvWriter writes delimited lines to a csv file, the records are ignored
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w csvWriter) Write(line []string, _ map[string]interface{}) error {
+	return w.Writer.Write(line)
+}
+
+// quoteAllWriter writes csv lines whose fields are all quoted, csv.Writer only quotes the fields that need it. Like
+// csv.Writer, it writes the line breaks inside the fields as \r\n when useCRLF is set.
+type quoteAllWriter struct {
+	writer  *bufio.Writer
+	comma   rune
+	useCRLF bool
+	err     error
+}
+
+func (w *quoteAllWriter) Write(line []string, _ map[string]interface{}) error {
+	for i, field := range line {
+		if i > 0 {
+			w.writer.WriteRune(w.comma)
+		}
+		w.writer.WriteByte('"')
+		for _, r := range field {
+			switch {
+			case r == '"':
+				w.writer.WriteString(`""`)
+			case r == '\r' && w.useCRLF:
+				// dropped, the line break that follows it is written as \r\n
+			case r == '\n' && w.useCRLF:
+				w.writer.WriteString("\r\n")
+			default:
+				w.writer.WriteRune(r)
+			}
+		}
+		w.writer.WriteByte('"')
+	}
+	lineBreak := "\n"
+	if w.useCRLF {
+		lineBreak = "\r\n"
+	}
+	// the bufio.Writer keeps the first error, it is returned by every following write
+	if _, err := w.writer.WriteString(lineBreak); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *quoteAllWriter) Flush() {
+	if err := w.writer.Flush(); err != nil {
+		w.err = err
+	}
+}
+
+func (w *quoteAllWriter) Error() error {
+	return w.err
+}
+
+// jsonReader reads a JSON object per line. The blank lines are skipped.
+type jsonReader struct {
+	reader     *bufio.Reader
+	lineNumber int
+	offset     int64
+}
+
+func (r *jsonReader) InputOffset() int64 {
+	return r.offset
+}
+
+func (r *jsonReader) Read() (Input, error) {
+	for {
+		raw, err := r.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(raw) == 0) {
+			return Input{}, err
+		}
+		r.lineNumber++
+		r.offset += int64(len(raw))
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return Input{}, fmt.Errorf("invalid JSON object on line %d: %w", r.lineNumber, err)
+		}
+		return Input{Line: []string{string(raw)}, LineNumber: r.lineNumber, Record: record}, nil
+	}
+}
+
+// jsonWriter writes a JSON object per line, the lines are ignored
+type jsonWriter struct {
+	writer *bufio.Writer
+	//separator is written after every object
+	separator string
+	err       error
+}
+
+func (w *jsonWriter) Write(_ []string, record map[string]interface{}) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(append(encoded, w.separator...)); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *jsonWriter) Flush() {
+	if err := w.writer.Flush(); err != nil {
+		w.err = err
+	}
+}
+
+func (w *jsonWriter) Error() error {
+	return w.err
+}
+
+// lockWriter returns writer guarded by a mutex when it is shared by the goroutines of Config.WriteConcurrency
+func (p *fileProcessor) lockWriter(writer lineWriter) lineWriter {
+	if p.config.WriteConcurrency <= 1 {
+		return writer
+	}
+	return &lockedWriter{writer: writer}
+}
+
+// lockedWriter lets several goroutines use a lineWriter, one at a time
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer lineWriter
+}
+
+func (w *lockedWriter) Write(line []string, record map[string]interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(line, record)
+}
+
+func (w *lockedWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writer.Flush()
+}
+
+func (w *lockedWriter) Error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Error()
+}
+
+// newOutputWriter returns the writer of an output file: the Encoder when it is set, the Format otherwise. The records
+// of an Encoder or JSONL output end with the Config.RecordSeparator when it is set.
+func (p *fileProcessor) newOutputWriter(file io.Writer) lineWriter {
+	if p.config.Encoder != nil {
+		return &encodedWriter{writer: bufio.NewWriter(file), encoder: p.config.Encoder, separator: p.config.RecordSeparator}
+	}
+	if p.config.Format == JSONL && p.config.RecordSeparator != "" {
+		return &jsonWriter{writer: bufio.NewWriter(file), separator: p.config.RecordSeparator}
+	}
+	return p.newWriter(file)
+}
+
+// encodedWriter writes the successful lines as encoded by an Encoder
+type encodedWriter struct {
+	writer  *bufio.Writer
+	encoder Encoder
+	//separator is written after every encoded line, the Encoder writes its own line break when it is empty
+	separator string
+	err       error
+}
+
+func (w *encodedWriter) Write(line []string, record map[string]interface{}) error {
+	encoded, err := w.encoder.EncodeLine(Output{Line: line, Record: record, Success: true})
+	if err != nil {
+		return fmt.Errorf("error encoding line: %w", err)
+	}
+	if _, err := w.writer.Write(append(encoded, w.separator...)); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *encodedWriter) Flush() {
+	if err := w.writer.Flush(); err != nil {
+		w.err = err
+	}
+}
+
+func (w *encodedWriter) Error() error {
+	return w.err
+}
+
+// sliceReader reads the rows handed to ProcessSlice, the LineNumber of a row is its position in rows plus one
+type sliceReader struct {
+	rows [][]string
+	next int
+}
+
+func (r *sliceReader) Read() (Input, error) {
+	if r.next >= len(r.rows) {
+		return Input{}, io.EOF
+	}
+	r.next++
+	return Input{Line: r.rows[r.next-1], LineNumber: r.next}, nil
+}
@@ -0,0 +1,56 @@
+package fileprocessor
+
+import "context"
+
+// RunOnce validates line and processes it the way a run does, without any file, so a Processor can be unit tested.
+// The validation error is returned as is and the line is then not processed. ProcessBatch is called instead of
+// Process when the processor is a BatchProcessor, ProcessWithState with a single state when it is WorkerScoped, and a
+// panic becomes a failed Output.
+func RunOnce(processor Processor, line []string) (Output, error) {
+	outputs, err := RunLines(processor, [][]string{line})
+	if err != nil {
+		return Output{}, err
+	}
+	return outputs[0], nil
+}
+
+// RunLines is like RunOnce for several lines, their Outputs are returned in the same order. Like a run without
+// Config.SkipInvalid, every line is validated first and the first invalid line is returned as a LineError, counting
+// the lines from 1, before any line is processed. Batch processors receive the lines in batches of up to 100.
+func RunLines(processor Processor, lines [][]string) ([]Output, error) {
+	p, err := newFileProcessor(processor, Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]Input, len(lines))
+	for i, line := range lines {
+		if err := processor.Validate(line); err != nil {
+			return nil, LineError{LineNumber: i + 1, Err: err}
+		}
+		inputs[i] = Input{Line: line, LineNumber: i + 1, index: i}
+	}
+
+	ctx := context.Background()
+	stats := &WorkerSummary{}
+	batchProcessor, isBatch := processor.(BatchProcessor)
+	if !isBatch {
+		process, release := p.lineProcessor()
+		defer release()
+		outputs := make([]Output, len(inputs))
+		for i, input := range inputs {
+			outputs[i] = p.callProcess(ctx, process, input, stats)
+		}
+		return outputs, nil
+	}
+
+	outputs := make([]Output, 0, len(inputs))
+	for start := 0; start < len(inputs); start += p.config.BatchSize {
+		end := start + p.config.BatchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		outputs = append(outputs, p.callBatch(ctx, batchProcessor, inputs[start:end], stats)...)
+	}
+	return outputs, nil
+}
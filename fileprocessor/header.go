@@ -0,0 +1,132 @@
+package fileprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// HeaderAware can be implemented by a Processor that looks up the columns of a line by name. SetHeader is called
+// with the header of the input file before any line is validated, when Config.HasHeader is set. The column names are
+// normalized with NormalizeColumn when Config.NormalizeHeaders is set.
+type HeaderAware interface {
+	//SetHeader receives the column names of the input file
+	SetHeader([]string)
+}
+
+// Header maps the column names of a header to their position in the line
+type Header map[string]int
+
+// NewHeader returns the Header of the given column names. When a name is repeated its first position is kept.
+func NewHeader(columns []string) Header {
+	header := make(Header, len(columns))
+	for i, column := range columns {
+		if _, ok := header[column]; !ok {
+			header[column] = i
+		}
+	}
+	return header
+}
+
+// Field returns the value of the named column in line. It returns false when the header has no such column or the
+// line is too short to hold it.
+func (h Header) Field(line []string, column string) (string, bool) {
+	i, ok := h[column]
+	if !ok || i >= len(line) {
+		return "", false
+	}
+	return line[i], true
+}
+
+// NormalizeColumn returns the canonical form of a column name: trimmed, lowercased and with the inner spaces turned
+// into underscores, so "User ID " becomes "user_id"
+func NormalizeColumn(name string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(name, unicode.IsSpace), "_"))
+}
+
+// NormalizeColumns returns a copy of columns whose names are normalized with NormalizeColumn
+func NormalizeColumns(columns []string) []string {
+	normalized := make([]string, len(columns))
+	for i, column := range columns {
+		normalized[i] = NormalizeColumn(column)
+	}
+	return normalized
+}
+
+// DuplicateColumnPolicy tells what to do with a header where several columns have the same name, which makes the
+// lookups by name ambiguous
+type DuplicateColumnPolicy int
+
+const (
+	//AllowDuplicateColumns hands the header as it is, a lookup by name finds the first column, it is the default
+	AllowDuplicateColumns DuplicateColumnPolicy = iota
+	//RejectDuplicateColumns stops the run before any line is read
+	RejectDuplicateColumns
+	//RenameDuplicateColumns adds the occurrence number to the repeated names, so id, id becomes id, id_2
+	RenameDuplicateColumns
+)
+
+func (d DuplicateColumnPolicy) String() string {
+	switch d {
+	case AllowDuplicateColumns:
+		return "allow"
+	case RejectDuplicateColumns:
+		return "reject"
+	case RenameDuplicateColumns:
+		return "rename"
+	}
+	return fmt.Sprintf("DuplicateColumnPolicy(%d)", int(d))
+}
+
+// parseDuplicateColumnPolicy converts the duplicateColumns argument into a DuplicateColumnPolicy
+func parseDuplicateColumnPolicy(value string) (DuplicateColumnPolicy, error) {
+	for _, policy := range []DuplicateColumnPolicy{AllowDuplicateColumns, RejectDuplicateColumns, RenameDuplicateColumns} {
+		if value == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -duplicateColumns argument %q, it must be allow, reject or rename", value)
+}
+
+// checkColumns applies policy to the repeated names of columns. It returns an error telling the first repeated name
+// with RejectDuplicateColumns, and a copy of columns with the repeated names renamed with RenameDuplicateColumns.
+func checkColumns(columns []string, policy DuplicateColumnPolicy) ([]string, error) {
+	if policy == AllowDuplicateColumns {
+		return columns, nil
+	}
+	first := make(map[string]int, len(columns))
+	for i, column := range columns {
+		if position, ok := first[column]; ok {
+			if policy == RejectDuplicateColumns {
+				return nil, fmt.Errorf("%w: duplicate column %q in the header, at positions %d and %d", ErrInvalidInput,
+					column, position, i)
+			}
+			continue
+		}
+		first[column] = i
+	}
+	if len(first) == len(columns) {
+		return columns, nil
+	}
+
+	renamed := make([]string, len(columns))
+	taken := make(map[string]bool, len(columns))
+	occurrences := make(map[string]int, len(first))
+	for i, column := range columns {
+		occurrences[column]++
+		name := column
+		if first[column] != i {
+			// a renamed column must not take the name of another column, such as an id_2 already in the header
+			for n := occurrences[column]; ; n++ {
+				name = column + "_" + strconv.Itoa(n)
+				if _, ok := first[name]; !ok && !taken[name] {
+					break
+				}
+			}
+		}
+		taken[name] = true
+		renamed[i] = name
+	}
+	return renamed, nil
+}
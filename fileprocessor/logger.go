@@ -0,0 +1,125 @@
+package fileprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Logger receives the progress messages of a run. A *log.Logger can be used as a Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// nopLogger discards every message, it is used when no Logger is configured
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// LogLevel is the amount of messages sent to the Logger
+type LogLevel int
+
+const (
+	//QuietLog only sends the final totals and the errors, without the banner, the workers and the progress lines
+	QuietLog LogLevel = iota - 1
+	//NormalLog sends the banner, the workers, the progress lines and the final totals, it is the default
+	NormalLog
+	//VerboseLog also sends the outcome of every line along with its processing time and error, even without
+	//Config.ShowDescription
+	VerboseLog
+)
+
+// LogFormat is the format of the messages sent to the Logger
+type LogFormat int
+
+const (
+	//TextLog sends human readable messages, it is the default
+	TextLog LogFormat = iota
+	//JSONLog sends every message as a JSON object, such as {"event":"progress","processed":100,...}, for the log
+	//aggregators
+	JSONLog
+)
+
+func (f LogFormat) String() string {
+	switch f {
+	case TextLog:
+		return "text"
+	case JSONLog:
+		return "json"
+	}
+	return fmt.Sprintf("LogFormat(%d)", int(f))
+}
+
+// parseLogFormat converts the logFormat argument into a LogFormat
+func parseLogFormat(value string) (LogFormat, error) {
+	for _, format := range []LogFormat{TextLog, JSONLog} {
+		if value == format.String() {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -logFormat argument %q, it must be text or json", value)
+}
+
+// countsEvent is the JSONLog message of the progress and of the end of a run
+type countsEvent struct {
+	Event     string `json:"event"`
+	Processed int64  `json:"processed"`
+	Success   int64  `json:"success"`
+	Failure   int64  `json:"failure"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+}
+
+// workerEvent is the JSONLog message of the start of a worker, and of the lines it processed once the run is over
+type workerEvent struct {
+	Event     string `json:"event"`
+	WorkerID  int    `json:"worker_id"`
+	Processed int64  `json:"processed"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+}
+
+// messageEvent is the JSONLog message holding any other message
+type messageEvent struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// jsonLogger sends every message to Logger as a JSON object on its own line
+type jsonLogger struct {
+	Logger
+}
+
+func (l jsonLogger) Printf(format string, v ...interface{}) {
+	l.event(messageEvent{Event: "message", Message: strings.TrimSpace(fmt.Sprintf(format, v...))})
+}
+
+// event sends event encoded as JSON
+func (l jsonLogger) event(event interface{}) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		l.Logger.Printf("error encoding log event: %v", err)
+		return
+	}
+	l.Logger.Printf("%s", encoded)
+}
+
+// infof sends a message that is left out by QuietLog
+func (p *fileProcessor) infof(format string, v ...interface{}) {
+	if !p.quiet() {
+		p.logger.Printf(format, v...)
+	}
+}
+
+// quiet tells if only the final totals and the errors are sent to the Logger
+func (p *fileProcessor) quiet() bool {
+	return p.config.LogLevel <= QuietLog
+}
+
+// logEvent sends event to the Logger when the LogFormat is JSONLog. It returns false otherwise, so the caller sends
+// its text message instead.
+func (p *fileProcessor) logEvent(event interface{}) bool {
+	logger, ok := p.logger.(jsonLogger)
+	if ok {
+		logger.event(event)
+	}
+	return ok
+}
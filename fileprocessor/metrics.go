@@ -0,0 +1,19 @@
+package fileprocessor
+
+import "time"
+
+// Metrics receives the measures of a run while it goes, for instance to expose them to Prometheus. Its methods are
+// called from several goroutines at once, so they must be safe for concurrent use.
+type Metrics interface {
+	//Processed records a Process or ProcessBatch call that handled the given number of lines in d
+	Processed(lines int, d time.Duration)
+	//Written records a line written to the output file when success is true, or to the failures file otherwise
+	Written(success bool)
+}
+
+// nopMetrics discards every measure, it is used when no Metrics is configured
+type nopMetrics struct{}
+
+func (nopMetrics) Processed(int, time.Duration) {}
+
+func (nopMetrics) Written(bool) {}
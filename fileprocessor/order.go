@@ -0,0 +1,95 @@
+package fileprocessor
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ReorderPolicy tells what a run with Config.PreserveOrder does once Config.ReorderWindow lines are held waiting for
+// a late line
+type ReorderPolicy int
+
+const (
+	//BlockReorder stops reading until the late line is written, so the order is always kept. It is the default
+	BlockReorder ReorderPolicy = iota
+	//FlushReorder writes the held lines without waiting for the late line, which is written once processed. A warning
+	//is logged as the order is then lost
+	FlushReorder
+)
+
+func (r ReorderPolicy) String() string {
+	switch r {
+	case BlockReorder:
+		return "block"
+	case FlushReorder:
+		return "flush"
+	}
+	return fmt.Sprintf("ReorderPolicy(%d)", int(r))
+}
+
+// parseReorderPolicy converts the reorderPolicy argument into a ReorderPolicy
+func parseReorderPolicy(value string) (ReorderPolicy, error) {
+	for _, policy := range []ReorderPolicy{BlockReorder, FlushReorder} {
+		if value == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -reorderPolicy argument %q, it must be block or flush", value)
+}
+
+// writeOrdered writes results in the same order their lines were read. The results that arrive before their turn
+// are held until all the previous lines are written. With FlushReorder no more than Config.ReorderWindow of them are
+// held: once there are more, the held lines are written up to the next late line and the late lines are written as
+// soon as they arrive.
+func (p *fileProcessor) writeOrdered(results <-chan result) {
+	pending := make(map[int]result)
+	next := p.resumeFrom
+	window := 0
+	if p.config.ReorderPolicy == FlushReorder {
+		window = p.config.ReorderWindow
+	}
+	for record := range results {
+		if record.Input.index < next {
+			// a late line whose turn was given up
+			p.write(record)
+			continue
+		}
+		pending[record.Input.index] = record
+		if window > 0 && len(pending) > window {
+			first := firstIndex(pending)
+			p.logger.Printf("warning: %d lines wait for a late line, they are written out of order from line %d",
+				len(pending), pending[first].Input.LineNumber)
+			next = first
+		}
+		for {
+			record, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			p.write(record)
+			next++
+		}
+	}
+
+	// the run was cancelled before some lines were processed, write what is left keeping the order
+	indexes := make([]int, 0, len(pending))
+	for index := range pending {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		p.write(pending[index])
+	}
+}
+
+// firstIndex returns the lowest index of pending, which is not empty
+func firstIndex(pending map[int]result) int {
+	first := -1
+	for index := range pending {
+		if first == -1 || index < first {
+			first = index
+		}
+	}
+	return first
+}
@@ -0,0 +1,85 @@
+package fileprocessor
+
+import (
+	"context"
+	"sync"
+)
+
+// pauseGate holds the workers back while the run is paused
+type pauseGate struct {
+	mu sync.Mutex
+	//resumed is closed when the run is resumed, it is nil while the run is not paused
+	resumed chan struct{}
+}
+
+// setPaused pauses or resumes the run, and tells if that changed its state
+func (g *pauseGate) setPaused(paused bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if paused == (g.resumed != nil) {
+		return false
+	}
+	if paused {
+		g.resumed = make(chan struct{})
+	} else {
+		close(g.resumed)
+		g.resumed = nil
+	}
+	return true
+}
+
+// wait returns once the run is not paused, or false when ctx is done first
+func (g *pauseGate) wait(ctx context.Context) bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watchPause pauses and resumes the run according to the values received from Config.Pause until the returned
+// function is called. The run is resumed when the channel is closed.
+func (p *fileProcessor) watchPause(ctx context.Context) func() {
+	if p.config.Pause == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case paused, ok := <-p.config.Pause:
+				if !ok {
+					p.setPaused(false)
+					return
+				}
+				p.setPaused(paused)
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// setPaused pauses or resumes the workers, and logs it when it changes the state of the run
+func (p *fileProcessor) setPaused(paused bool) {
+	if !p.pause.setPaused(paused) {
+		return
+	}
+	if paused {
+		p.logger.Printf("processing paused")
+	} else {
+		p.logger.Printf("processing resumed")
+	}
+}
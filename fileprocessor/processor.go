@@ -1,19 +1,20 @@
 package fileprocessor
 
 import (
-	"bufio"
+	"context"
 	"encoding/csv"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-)
 
-const (
-	defaultRoutines = 25
+	"golang.org/x/time/rate"
 )
 
 type Processor interface {
@@ -27,139 +28,775 @@ type Processor interface {
 	SetToken(string)
 }
 
+// BatchProcessor can be implemented by a Processor whose lines are cheaper to process together, for instance when
+// they are sent to an API with a bulk endpoint. ProcessBatch is then called instead of Process.
+type BatchProcessor interface {
+	//ProcessBatch processes the given Inputs and returns one Output per Input, in the same order
+	ProcessBatch([]Input) []Output
+}
+
+// Configurable can be implemented by a Processor that needs more settings than the token, such as a base URL or a
+// region. Configure is called with Config.Settings before any line is processed.
+type Configurable interface {
+	//Configure receives the settings of the run, an error stops the run before it starts
+	Configure(map[string]string) error
+}
+
+// Finalizer can be implemented by a Processor that needs to release resources or report the end of the run, for
+// instance closing a database connection. Finalize is called once per run, after every line is written and the output
+// files are flushed and closed.
+type Finalizer interface {
+	//Finalize receives the Summary of the run, its error is returned by the run unless it already failed
+	Finalize(Summary) error
+}
+
+// HealthChecker can be implemented by a Processor that depends on a remote system, to check that its token works and
+// the system can be reached before any line is processed. HealthCheck is called once, after SetToken and Configure
+// and before the workers start. It is not called on a dry run.
+type HealthChecker interface {
+	//HealthCheck returns an error when the Processor cannot work, which stops the run before it starts
+	HealthCheck() error
+}
+
+// WorkerScoped can be implemented by a Processor whose lines need a resource that is costly to create and not safe to
+// share among the workers, such as a database or an API client. NewWorkerState is called once by every worker, which
+// then calls ProcessWithState with its own state instead of Process. A state that is an io.Closer is closed once its
+// worker is done. A call that exceeds Config.ProcessTimeout keeps its state, closed once the call returns, and its
+// worker gets a new state from NewWorkerState. It has no effect on a BatchProcessor.
+type WorkerScoped interface {
+	//NewWorkerState returns the state of a new worker
+	NewWorkerState() interface{}
+	//ProcessWithState processes the given Input like Process, with the state of the worker processing it
+	ProcessWithState(Input, interface{}) Output
+}
+
 type Input struct {
+	//Line holds the fields of a csv line, or the JSON object as its only field when the Format is JSONL
 	Line []string
+	//Record is the decoded JSON object when the Format is JSONL, nil otherwise
+	Record map[string]interface{}
+	//LineNumber is the line of the input file where the Line starts
+	LineNumber int
+	//index is the position of the Line among the lines read, used to keep the input order
+	index int
 }
 
 type Output struct {
-	Line    []string
+	//Line is the line written to the output file on success, the Input Line is written when it is nil
+	Line []string
+	//Record is the JSON object written to the output file on success when the Format is JSONL, the Input Record is
+	//written when it is nil
+	Record  map[string]interface{}
 	Error   error
 	Success bool
+	//Skipped indicates that the line is intentionally left out, it is neither a success nor a failure. Success and
+	//Error are then ignored
+	Skipped bool
 }
 
 type result struct {
 	Input  Input
 	Output Output
+	//invalid indicates that the Input did not pass the validation and was not processed
+	invalid bool
+	//duration is the time spent processing the Input, its retries included. The lines of a batch share its time
+	duration time.Duration
+	//workerID is the id of the worker that processed the Input, 0 for an invalid or filtered line
+	workerID int
+	//filtered indicates that the Input was rejected by the Filter of the Processor and was not processed
+	filtered bool
+	//leftOut indicates that the Input is a duplicate or already completed, it is neither processed nor written and
+	//only keeps its place in the order and the checkpoint
+	leftOut bool
 }
 
 type fileProcessor struct {
 	inputs    chan Input
 	results   chan result
 	processor Processor
+	config    Config
+	logger    Logger
+	metrics   Metrics
+	//limiter is shared by all the workers, nil when there is no rate limit
+	limiter *rate.Limiter
+	//inFlight holds a slot for every line read and not written yet, nil when there is no Config.MaxInFlight
+	inFlight chan struct{}
+	//pause holds the workers back while the run is paused through Config.Pause
+	pause pauseGate
+	//abort stops the reader and the workers, abortErr tells why. They are only used by the results loop
+	abort    context.CancelFunc
+	abortErr error
+	//stream receives the Result of every line written by ProcessStream, nil otherwise
+	stream chan<- Result
+	//columnRules are the Config.ColumnRules along with the position of their column, in that order
+	columnRules []columnRule
+	//writeMu is held by the results loop while it writes a result. With Config.WriteConcurrency it is released while
+	//a line is written to its file, each writer being then locked on its own
+	writeMu sync.Mutex
+
+	//readCount is the number of lines read so far, only used by the readers through atomic operations
+	readCount int64
+	//sentCount is the number of lines sent to the inputs or the results so far, only used by the readers through
+	//atomic operations
+	sentCount int64
+	//rangePath is the input file read by byte ranges, empty when the input is read sequentially
+	rangePath string
+	//seenIdentifiers holds the identifiers of the lines read when Config.Deduplicate is set
+	seenIdentifiers map[uint64]struct{}
+	//completed holds the identifiers read from Config.CompletedPath, nil when it is not set
+	completed map[uint64]struct{}
+	//completedWriter appends the identifiers of the successful lines to Config.CompletedPath
+	completedWriter lineWriter
+	//writtenIdentifiers holds the identifiers written to the file that wins under Config.DedupePolicy, only used by
+	//the results loop
+	writtenIdentifiers map[uint64]struct{}
+	//appendOutput indicates that the output files keep their content, they are not truncated nor get a header
+	//unless they are in emptyOutputs
+	appendOutput bool
+	//emptyOutputs holds the paths of the output files appended to that do not exist or are empty, they get a header
+	emptyOutputs map[string]bool
+	//resumeFrom is the number of lines written by the run being resumed, they are skipped
+	resumeFrom int
+	//checkpoint is the progress of the run, only used by the results loop
+	checkpoint checkpoint
+	//written holds the line numbers of the lines written ahead of the checkpoint, by line index
+	written map[int]int
+
+	//expectedTotal is the number of lines expected to be written, 0 when unknown
+	expectedTotal int64
+	//headerWidth is the number of columns of the input header, 0 when there is no header
+	headerWidth int
+	//outputWidth is the number of columns of the output header, 0 when there is no header
+	outputWidth int
+
+	//successWriters holds a writer per output shard, there is a single one unless Config.OutputShards is above 1
+	successWriters []lineWriter
+	failureWriter  lineWriter
+	//skippedWriter is nil when the skipped lines are not written
+	skippedWriter lineWriter
+	//writeErrorWriter receives the lines that could not be written to their file, nil when there is no such file
+	writeErrorWriter lineWriter
+
+	//the counters are only written by the reader and the results loop, atomically so the stats server can read them
+	//while the run goes on
+	successCounter int64
+	failureCounter int64
+	//validationFailureCounter is the part of the failureCounter made of invalid lines, the rest failed in Process
+	validationFailureCounter int64
+	skippedCounter           int64
+	//droppedCounter is the number of lines left out by the Config.DedupePolicy
+	droppedCounter int64
+	//writeErrorCounter is the number of lines that could not be written to their file
+	writeErrorCounter int64
+	//filteredCounter is the number of lines rejected by the Filter of the Processor
+	filteredCounter int64
+	totalCounter    int64
+	invalidCounter  int64
+	//workerStats holds the activity of each worker, indexed by worker id - 1
+	workerStats []WorkerSummary
+	//duplicateCounter and completedCounter are only written by the reader
+	duplicateCounter int64
+	completedCounter int64
+	//invalidLines holds the validation errors found on a dry run
+	invalidLines []LineError
+	//slowest holds the Config.SlowestLines slowest lines processed, it is only used by the results loop
+	slowest slowestLines
+
+	//truncated indicates that the results loop stopped before the workers were done, after Config.ShutdownTimeout.
+	//The workers may still be running, so their stats are not read
+	truncated bool
+
+	start time.Time
+	end   time.Time
+}
+
+// ProcessE processes the input file described by cfg and returns any error found instead of exiting
+func ProcessE(processor Processor, cfg Config) error {
+	_, err := ProcessWithConfig(processor, cfg)
+	return err
+}
+
+// ProcessWithConfig processes the input file described by cfg and returns the Summary of the run. It does not read
+// the program arguments, so it can be called several times within the same program.
+//
+// A SIGINT or SIGTERM received during the run stops it like a cancelled ProcessContext would: the lines already
+// processed are flushed to the output files before returning.
+func ProcessWithConfig(processor Processor, cfg Config) (Summary, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := ProcessContext(ctx, processor, cfg)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("run interrupted by a signal: %w", err)
+	}
+	return summary, err
+}
+
+// ProcessContext is like ProcessWithConfig but stops when ctx is done. The input stops being read, the workers
+// finish the lines they hold, the lines already processed are flushed to the output files and ctx.Err() is returned
+// along with the Summary of the lines processed so far.
+func ProcessContext(ctx context.Context, processor Processor, cfg Config) (Summary, error) {
+	fProcessor, err := newFileProcessor(processor, cfg)
+	if err != nil {
+		return Summary{}, err
+	}
+	return fProcessor.finish(fProcessor.limitDuration(ctx, fProcessor.run))
+}
+
+// ProcessReader is like ProcessContext but reads the lines from input and writes them to output and failures instead
+// of the files described by cfg, whose paths and OutputShards are ignored. The readers and writers are neither
+// decompressed, compressed nor closed. output and failures can be nil on a dry run, failures is not used with
+// Config.CombinedOutput.
+func ProcessReader(ctx context.Context, processor Processor, input io.Reader, output, failures io.Writer,
+	cfg Config) (Summary, error) {
+	cfg.OutputShards = 0
+	fProcessor, err := newFileProcessor(processor, cfg)
+	if err != nil {
+		return Summary{}, err
+	}
+	return fProcessor.finish(fProcessor.limitDuration(ctx, func(ctx context.Context) error {
+		if err := fProcessor.configure(); err != nil {
+			return err
+		}
+		return fProcessor.process(ctx, input, nil, []io.Writer{output}, failures, nil, nil)
+	}))
+}
+
+// ProcessSlice processes rows with the worker pool described by cfg and returns the Output of every row, in the order
+// of rows. No file is read nor written, the paths of cfg are ignored and every row is a line to process, none is a
+// header. The Output of a row left out as a duplicate, or not processed because the run stopped early, is the zero
+// Output. The invalid rows get an Output holding their validation error when cfg.SkipInvalid is set, otherwise the
+// first one stops the run.
+func ProcessSlice(processor Processor, rows [][]string, cfg Config) ([]Output, error) {
+	cfg.DryRun = false
+	cfg.CheckpointPath = ""
+	cfg.SummaryPath = ""
+	fProcessor, err := newFileProcessor(processor, cfg)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]Output, len(rows))
+	_, err = fProcessor.finish(fProcessor.processSlice(rows, outputs))
+	return outputs, err
+}
+
+// processSlice processes rows and stores the Output of every row at its index in outputs. The results go through the
+// usual write so the counters, the metrics and the failure limits work as in a run over files.
+func (p *fileProcessor) processSlice(rows [][]string, outputs []Output) error {
+	if err := p.configure(); err != nil {
+		return err
+	}
+	if err := p.resolveColumnRules(nil); err != nil {
+		return err
+	}
+	p.successWriters = []lineWriter{p.newWriter(io.Discard)}
+	p.failureWriter = p.newWriter(io.Discard)
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	p.abort = abort
+
+	readErr := p.runPool(ctx, &sliceReader{rows: rows}, nil, func() {
+		for record := range p.results {
+			outputs[record.Input.LineNumber-1] = record.Output
+			p.write(record)
+		}
+	})
+	if p.abortErr != nil {
+		return p.abortErr
+	}
+	return readErr
 }
 
-func Process(processor Processor) {
+func newFileProcessor(processor Processor, cfg Config) (*fileProcessor, error) {
 	if processor == nil {
-		log.Fatal("processor cannot be nil")
+		return nil, errors.New("processor cannot be nil")
 	}
 
-	fProcessor := fileProcessor{
-		inputs:    make(chan Input, 100),
-		results:   make(chan result, 100),
-		processor: processor,
+	cfg = cfg.withDefaults()
+	if cfg.Encoder != nil && cfg.CombinedOutput {
+		return nil, fmt.Errorf("%w: the output cannot be combined when written by an Encoder", ErrInvalidConfig)
 	}
+	fProcessor := &fileProcessor{
+		inputs:    make(chan Input, cfg.InputBuffer),
+		results:   make(chan result, cfg.ResultBuffer),
+		processor: processor,
+		config:    cfg,
+		logger:    cfg.Logger,
+		metrics:   cfg.Metrics,
 
-	fProcessor.run()
+		seenIdentifiers:    make(map[uint64]struct{}),
+		writtenIdentifiers: make(map[uint64]struct{}),
+		written:            make(map[int]int),
+	}
+	if fProcessor.logger == nil {
+		fProcessor.logger = nopLogger{}
+	} else if cfg.LogFormat == JSONLog {
+		fProcessor.logger = jsonLogger{fProcessor.logger}
+	}
+	if fProcessor.metrics == nil {
+		fProcessor.metrics = nopMetrics{}
+	}
+	if cfg.RateLimit > 0 {
+		fProcessor.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), 1)
+	}
+	limit := cfg.MaxInFlight
+	if cfg.PreserveOrder && cfg.ReorderPolicy == BlockReorder && cfg.ReorderWindow > 0 &&
+		(limit <= 0 || cfg.ReorderWindow < limit) {
+		// the lines behind a late line are all in flight, so bounding them bounds the lines held for their turn
+		limit = cfg.ReorderWindow
+	}
+	if limit > 0 {
+		if _, ok := processor.(BatchProcessor); ok && limit < cfg.Threads*cfg.BatchSize {
+			// every worker must be able to fill its batch, or they would all wait for lines that cannot be read
+			limit = cfg.Threads * cfg.BatchSize
+		}
+		fProcessor.inFlight = make(chan struct{}, limit)
+	}
+	return fProcessor, nil
 }
 
-func (p fileProcessor) run() {
-	var inputPathArg = "inputPath"
-	var outputPathArg = "outputPath"
-	var tokenArg = "token"
-	inputPathPtr := flag.String(inputPathArg, "default input", "input file path")
-	outputPathPtr := flag.String(outputPathArg, "default output", "output file path")
-	routinesNumberPtr := flag.Int("threads", defaultRoutines, "number of parallel executions")
-	hasHeaderPtr := flag.Bool("hasHeader", true, "indicates if the input file has a header or not, true by default")
-	token := flag.String(tokenArg, "", "access token")
-	showDescription := flag.Bool("showDescription", false, "is description shown")
+// limitDuration calls run with ctx limited to Config.MaxDuration. A run stopped by that limit returns an error
+// wrapping context.DeadlineExceeded.
+func (p *fileProcessor) limitDuration(ctx context.Context, run func(context.Context) error) error {
+	if p.config.MaxDuration <= 0 {
+		return run(ctx)
+	}
+	limited, cancel := context.WithTimeout(ctx, p.config.MaxDuration)
+	defer cancel()
 
-	requiredArguments := []string{inputPathArg, outputPathArg}
-	if p.processor != nil {
-		requiredArguments = append(requiredArguments, tokenArg)
+	err := run(limited)
+	if err != nil && ctx.Err() == nil && limited.Err() != nil {
+		err = fmt.Errorf("run stopped once its maximum duration of %v elapsed: %w", p.config.MaxDuration, err)
 	}
-	flag.Parse()
+	return err
+}
+
+// ErrLinesFailed is returned by a run with Config.FailOnAnyError where some lines failed, or were invalid on a dry run
+var ErrLinesFailed = errors.New("lines failed")
+
+// ErrInvalidInput is returned by a run stopped by its input data, such as an invalid line without Config.SkipInvalid
+// or a header rejected by Config.DuplicateColumns
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrInvalidConfig is returned by a run whose Config is contradictory or incomplete, such as Resume without a
+// CheckpointPath, before any line is read
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrHealthCheck is returned by a run whose HealthChecker Processor failed its health check, no line is processed
+var ErrHealthCheck = errors.New("processor health check failed")
 
-	seen := make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) { seen[f.Name] = true })
-	for _, req := range requiredArguments {
-		if !seen[req] {
-			fmt.Fprintf(os.Stderr, "missing requiredArguments -%s argument\n", req)
-			os.Exit(2) // the same exit code flag.Parse uses
+// finish returns the Summary of the run that ended with err, writes it to Config.SummaryPath and hands it to the
+// Finalizer
+func (p *fileProcessor) finish(err error) (Summary, error) {
+	var summary Summary
+	// the Summary stays empty when the run failed before any line was processed
+	if !p.start.IsZero() {
+		summary = p.summary()
+		left := p.expectedTotal - p.totalCounter - p.duplicateCounter - p.completedCounter
+		if err != nil && p.expectedTotal > 0 && left > 0 {
+			summary.Unprocessed = left
+		}
+		if p.config.SummaryPath != "" {
+			if summaryErr := writeSummary(p.config.SummaryPath, summary); summaryErr != nil && err == nil {
+				err = summaryErr
+			}
 		}
 	}
 
-	if p.processor != nil {
-		p.processor.SetToken(*token)
+	failed := summary.Failure
+	if p.config.DryRun {
+		failed = summary.Invalid
+	}
+	if err == nil && p.config.FailOnAnyError && failed > 0 {
+		err = fmt.Errorf("%w: %d of %d lines", ErrLinesFailed, failed, summary.Total)
 	}
 
-	inputFile, err := os.Open(*inputPathPtr)
-	if err != nil {
-		log.Fatalf("error opening input file %v", err)
+	if finalizer, ok := p.processor.(Finalizer); ok {
+		if finalizeErr := finalizer.Finalize(summary); finalizeErr != nil && err == nil {
+			err = fmt.Errorf("error finalizing the processor: %w", finalizeErr)
+		}
 	}
+	return summary, err
+}
+
+// run opens the files described by the Config and processes them
+func (p *fileProcessor) run(ctx context.Context) (err error) {
+	cfg := p.config
 
-	outputFile, err := os.Create(*outputPathPtr)
+	inputPaths, err := resolveInputPaths(cfg.InputPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Format == CSV && cfg.Comment != 0 && cfg.Comment == cfg.Delimiter {
+		return fmt.Errorf("%w: the comment character cannot be the delimiter", ErrInvalidConfig)
+	}
+	inputFile, err := openInput(inputPaths[0], cfg.Compressed)
 	if err != nil {
-		log.Fatal("error creating output file")
+		return fmt.Errorf("%w: %w", ErrInputOpen, err)
 	}
+	defer inputFile.Close()
 
-	fmt.Println("---------------------------------------------------------------")
-	fmt.Println("Process started")
-	fmt.Println("---------------------------------------------------------------")
-	fmt.Printf("input file path: %s\n", *inputPathPtr)
-	fmt.Printf("output file path: %s\n", *outputPathPtr)
-	fmt.Printf("number of parallel executions: %d\n", *routinesNumberPtr)
-	fmt.Printf("header presence: %t\n", *hasHeaderPtr)
-	if *token != "" {
-		fmt.Printf("token: %s\n", *token)
+	// the processor is configured and checked before the output files of a previous run are replaced
+	if err := p.configure(); err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		return p.process(ctx, inputFile, inputPaths[1:], nil, nil, nil, nil)
 	}
-	fmt.Printf("---------------------------------------------------------------")
-	fmt.Printf("\n\n\n\n")
 
-	//Success Writer:
-	successWriter := csv.NewWriter(outputFile)
-	defer successWriter.Flush()
+	if cfg.Resume {
+		if cfg.CheckpointPath == "" {
+			return fmt.Errorf("%w: a checkpoint path is required to resume", ErrInvalidConfig)
+		}
+		p.checkpoint, err = readCheckpoint(cfg.CheckpointPath)
+		if err != nil {
+			return err
+		}
+		// the lines written by the previous run are kept
+		p.resumeFrom = p.checkpoint.Processed
+		p.appendOutput = p.resumeFrom > 0
+	}
 
-	//Failure Writer:
-	failuresFile, err := os.Create("failures.csv")
-	if err != nil {
-		log.Fatal("error creating output file")
+	if cfg.CompletedPath != "" {
+		p.completed, err = readCompleted(cfg.CompletedPath)
+		if err != nil {
+			return err
+		}
+		var completedFile *os.File
+		completedFile, err = os.OpenFile(cfg.CompletedPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("%w: completed identifiers file: %w", ErrOutputCreate, err)
+		}
+		defer closeFile(completedFile, cfg.CompletedPath, &err)
+		// the identifiers are flushed once the output files are, so they are never ahead of the lines written
+		p.completedWriter = csvWriter{csv.NewWriter(completedFile)}
+		defer flushWriter(p.completedWriter, &err)
 	}
-	failureWriter := csv.NewWriter(failuresFile)
-	defer failureWriter.Flush()
 
-	// Create a new reader.
-	reader := csv.NewReader(bufio.NewReader(inputFile))
-	if *hasHeaderPtr {
-		header, err := reader.Read()
+	if cfg.AtomicOutput && cfg.CheckpointPath != "" {
+		return fmt.Errorf("%w: the output cannot be atomic when a checkpoint path is set", ErrInvalidConfig)
+	}
+
+	if cfg.Append {
+		if cfg.AtomicOutput {
+			return fmt.Errorf("%w: the output cannot be atomic when appending to it", ErrInvalidConfig)
+		}
+		p.emptyOutputs = emptyFiles(append(p.outputPaths(), cfg.FailurePath, cfg.SkippedPath, cfg.WriteErrorPath)...)
+		p.appendOutput = true
+	}
+
+	if cfg.ProgressEvery > 0 || cfg.MaxDuration > 0 {
+		p.expectedTotal = p.countInputLines(inputPaths)
+	}
+
+	if cfg.OutputShards > 1 && cfg.OutputPath == stdStream {
+		return fmt.Errorf("%w: the output cannot be sharded when written to the standard output", ErrInvalidConfig)
+	}
+	var outputFiles []io.Writer
+	for _, path := range p.outputPaths() {
+		var outputFile io.WriteCloser
+		outputFile, err = p.newOutput(path)
 		if err != nil {
-			log.Fatal("error reading header from input file")
+			return fmt.Errorf("%w: %w", ErrOutputCreate, err)
 		}
+		defer closeOutput(outputFile, path, &err)
+		outputFiles = append(outputFiles, outputFile)
+	}
 
-		err = successWriter.Write(append(header))
+	var failuresFile io.WriteCloser
+	if !cfg.CombinedOutput {
+		failuresFile, err = p.newOutput(cfg.FailurePath)
 		if err != nil {
-			log.Fatal("error writing header to output file")
+			return fmt.Errorf("%w: failures file: %w", ErrOutputCreate, err)
 		}
+		defer closeOutput(failuresFile, cfg.FailurePath, &err)
+	}
 
-		if *showDescription {
-			err = failureWriter.Write(append(header, "error_description"))
-		} else {
-			err = failureWriter.Write(append(header))
+	var skippedFile io.WriteCloser
+	if cfg.SkippedPath != "" {
+		skippedFile, err = p.newOutput(cfg.SkippedPath)
+		if err != nil {
+			return fmt.Errorf("%w: skipped file: %w", ErrOutputCreate, err)
+		}
+		defer closeOutput(skippedFile, cfg.SkippedPath, &err)
+	}
+
+	var writeErrorFile io.WriteCloser
+	if cfg.WriteErrorPath != "" {
+		writeErrorFile, err = p.newOutput(cfg.WriteErrorPath)
+		if err != nil {
+			return fmt.Errorf("%w: write errors file: %w", ErrOutputCreate, err)
 		}
+		defer closeOutput(writeErrorFile, cfg.WriteErrorPath, &err)
+	}
+
+	p.rangePath = p.parallelPath(inputPaths)
+	return p.process(ctx, inputFile, inputPaths[1:], outputFiles, failuresFile, skippedFile, writeErrorFile)
+}
+
+// outputPaths returns the path of every output shard. The shards are named after Config.OutputPath with their index
+// before the extension, such as output-shard0.csv, output-shard1.csv...
+func (p *fileProcessor) outputPaths() []string {
+	if p.config.OutputShards <= 1 {
+		return []string{p.config.OutputPath}
+	}
+	paths := make([]string, p.config.OutputShards)
+	for i := range paths {
+		paths[i] = shardPath(p.config.OutputPath, i)
+	}
+	return paths
+}
+
+// shard returns the index of the output shard where the line of record is written: its Config.ShardKey, or its
+// identifier, modulo the number of shards. An invalid line goes to the first shard, as it may lack the columns of its
+// key.
+func (p *fileProcessor) shard(record result) int {
+	shards := len(p.successWriters)
+	if shards == 1 || record.invalid {
+		return 0
+	}
+	var key uint64
+	if p.config.ShardKey != nil {
+		key = p.config.ShardKey(record.Input)
+	} else {
+		_, key = p.processor.GetIdentifier(record.Input)
+	}
+	return int(key % uint64(shards))
+}
+
+// writesHeader tells if the output file at path gets a header: not when it keeps the lines of a previous run
+func (p *fileProcessor) writesHeader(path string) bool {
+	return !p.appendOutput || p.emptyOutputs[path]
+}
+
+// newOutput creates the output file at path, as a temporary file when Config.AtomicOutput is set
+func (p *fileProcessor) newOutput(path string) (io.WriteCloser, error) {
+	if p.config.AtomicOutput {
+		return createTempOutput(path, p.config.Compressed)
+	}
+	return createOutput(path, p.config.Compressed, p.appendOutput)
+}
+
+// process reads the lines from input, followed by the files at nextPaths, processes them and writes the results to
+// outputs, one per output shard, and failures. The skipped lines are written to skipped and the lines that cannot be
+// written to writeErrors, unless they are nil. The Processor must be configured beforehand.
+func (p *fileProcessor) process(ctx context.Context, input io.Reader, nextPaths []string, outputs []io.Writer,
+	failures, skipped, writeErrors io.Writer) (err error) {
+	cfg := p.config
+
+	// Create a new reader.
+	reader, err := p.newReader(input, cfg.SkipRows)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHeaderRead, err)
+	}
+	var header, columns []string
+	if cfg.HasHeader {
+		headerInput, err := reader.Read()
 		if err != nil {
-			log.Fatal("error writing header to output file")
+			return fmt.Errorf("%w: %w", ErrHeaderRead, err)
+		}
+		header = headerInput.Line
+		p.headerWidth = len(header)
+		columns = header
+		if cfg.NormalizeHeaders {
+			columns = NormalizeColumns(header)
+		}
+		// the names are checked once normalized, which can make two names the same
+		if columns, err = checkColumns(columns, cfg.DuplicateColumns); err != nil {
+			return err
+		}
+		if headerAware, ok := p.processor.(HeaderAware); ok {
+			headerAware.SetHeader(columns)
+		}
+	}
+	if err := p.resolveColumnRules(columns); err != nil {
+		return err
+	}
+
+	p.infof("---------------------------------------------------------------")
+	p.infof("Process started")
+	p.infof("---------------------------------------------------------------")
+	p.infof("version: %s", versionInfo())
+	p.infof("input file path: %s", cfg.InputPath)
+	p.infof("output file path: %s", cfg.OutputPath)
+	p.infof("number of parallel executions: %d", cfg.Threads)
+	p.infof("header presence: %t", cfg.HasHeader)
+	if cfg.Format != CSV {
+		p.infof("format: %s", cfg.Format)
+	}
+	if cfg.Token != "" {
+		p.infof("token: %s", maskToken(cfg.Token))
+	}
+	if cfg.DryRun {
+		p.infof("dry run: the lines are validated but not processed")
+	}
+	p.infof("---------------------------------------------------------------")
+	p.infof("\n\n")
+
+	if cfg.DryRun {
+		return p.dryRun(ctx, reader, nextPaths)
+	}
+
+	// the checkpoint is saved last, once both writers are flushed
+	defer func() {
+		if checkpointErr := p.saveCheckpoint(); checkpointErr != nil && err == nil {
+			err = checkpointErr
+		}
+	}()
+
+	//Success Writers:
+	p.successWriters = make([]lineWriter, len(outputs))
+	for i, output := range outputs {
+		p.successWriters[i] = p.lockWriter(p.newOutputWriter(output))
+		defer flushWriter(p.successWriters[i], &err)
+	}
+
+	//Failure Writer:
+	if cfg.CombinedOutput {
+		// the failures are written by the success writers
+		p.failureWriter = p.newWriter(io.Discard)
+	} else {
+		p.failureWriter = p.lockWriter(p.newWriter(failures))
+		defer flushWriter(p.failureWriter, &err)
+	}
+
+	//Skipped Writer:
+	if skipped != nil {
+		p.skippedWriter = p.lockWriter(p.newWriter(skipped))
+		defer flushWriter(p.skippedWriter, &err)
+	}
+
+	//Write Error Writer:
+	if writeErrors != nil {
+		p.writeErrorWriter = p.newWriter(writeErrors)
+		defer flushWriter(p.writeErrorWriter, &err)
+	}
+
+	outputHeader := header
+	if cfg.OutputHeader != nil && cfg.Format == CSV {
+		outputHeader = cfg.OutputHeader
+	}
+	p.outputWidth = len(outputHeader)
+	for i, path := range p.outputPaths() {
+		if outputHeader == nil || cfg.OmitOutputHeader || cfg.Encoder != nil || !p.writesHeader(path) {
+			continue
+		}
+		projected := p.transformHeader(p.project(outputHeader))
+		if cfg.CombinedOutput {
+			projected = p.combinedHeader(projected)
+		}
+		err = p.successWriters[i].Write(projected, nil)
+		if err != nil {
+			return fmt.Errorf("error writing header to output file: %w", err)
+		}
+	}
+
+	if cfg.HasHeader && !cfg.CombinedOutput && p.writesHeader(cfg.FailurePath) {
+		failureHeader := p.transformHeader(p.project(header))
+		if cfg.ShowDescription {
+			failureHeader = appendField(failureHeader, "error_description")
+		}
+		if cfg.FailureDurations {
+			failureHeader = appendField(failureHeader, "process_ms")
+		}
+		if cfg.FailureLineNumbers {
+			failureHeader = prependField(failureHeader, "line_number")
+		}
+		if err = p.failureWriter.Write(failureHeader, nil); err != nil {
+			return fmt.Errorf("error writing header to failures file: %w", err)
+		}
+	}
+
+	if cfg.HasHeader && p.skippedWriter != nil && p.writesHeader(cfg.SkippedPath) {
+		if err = p.skippedWriter.Write(header, nil); err != nil {
+			return fmt.Errorf("error writing header to skipped file: %w", err)
+		}
+	}
+
+	if cfg.HasHeader && p.writeErrorWriter != nil && p.writesHeader(cfg.WriteErrorPath) {
+		if err = p.writeErrorWriter.Write(appendField(header, "error_description"), nil); err != nil {
+			return fmt.Errorf("error writing header to write errors file: %w", err)
+		}
+	}
+
+	stopStats, err := p.serveStats()
+	if err != nil {
+		return err
+	}
+	defer stopStats()
+
+	// the results loop aborts the run through runCtx when too many lines fail
+	runCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	p.abort = abort
+
+	readErr := p.runPool(runCtx, reader, nextPaths, func() {
+		results := p.drain(runCtx)
+		switch {
+		case cfg.PreserveOrder:
+			p.writeOrdered(results)
+		case cfg.WriteConcurrency > 1:
+			p.writeConcurrently(results)
+		default:
+			for record := range results {
+				p.write(record)
+			}
+		}
+	})
+
+	p.logTotals()
+	if p.truncated {
+		p.logger.Printf("the workers did not finish within %v, the lines they still hold are not written",
+			cfg.ShutdownTimeout)
+	} else if !p.quiet() {
+		for _, worker := range p.workerStats {
+			event := workerEvent{Event: "worker_done", WorkerID: worker.ID, Processed: worker.Processed,
+				ElapsedMS: worker.ProcessTime.Milliseconds()}
+			if !p.logEvent(event) {
+				p.logger.Printf("worker %d: %d processed, %v processing", worker.ID, worker.Processed, worker.ProcessTime)
+			}
+		}
+	}
+
+	if p.abortErr != nil {
+		return p.abortErr
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return ctx.Err()
+}
+
+// configure hands the token and the settings of the Config to the Processor and runs its health check
+func (p *fileProcessor) configure() error {
+	p.processor.SetToken(p.config.Token)
+	if configurable, ok := p.processor.(Configurable); ok {
+		if err := configurable.Configure(p.config.Settings); err != nil {
+			return fmt.Errorf("error configuring the processor: %w", err)
 		}
 	}
+	if checker, ok := p.processor.(HealthChecker); ok && !p.config.DryRun {
+		if err := checker.HealthCheck(); err != nil {
+			return fmt.Errorf("%w: %w", ErrHealthCheck, err)
+		}
+	}
+	return nil
+}
 
-	var successCounter int64
-	var failureCounter int64
-	var totalCounter int64
-	routinesNumber := *routinesNumberPtr
-	start := time.Now()
+// runPool reads the lines from reader, followed by the files at nextPaths, and processes them with Config.Threads
+// workers. consume is called once and must read every result until the results are closed. runPool returns once
+// consume returns, with the error that stopped the reading.
+func (p *fileProcessor) runPool(ctx context.Context, reader lineReader, nextPaths []string, consume func()) error {
+	routinesNumber := p.config.Threads
+	p.workerStats = make([]WorkerSummary, routinesNumber)
+	p.start = time.Now()
 
+	// the reader takes part in the group because it writes the invalid lines to the results
 	group := sync.WaitGroup{}
-	group.Add(routinesNumber)
+	group.Add(routinesNumber + 1)
 	for w := 1; w <= routinesNumber; w++ {
-		go p.worker(w, &group)
+		go p.worker(ctx, w, &group)
 	}
 
 	go func() {
@@ -167,86 +804,546 @@ func (p fileProcessor) run() {
 		close(p.results)
 	}()
 
-	go p.readFile(reader)
-	count := 0
-	fmt.Println("starting to wait for results")
-	for record := range p.results {
-		count++
+	readErr := make(chan error, 1)
+	go func() {
+		defer group.Done()
+		if p.rangePath != "" {
+			readErr <- p.readRanges(ctx, p.rangePath)
+			return
+		}
+		readErr <- p.readFiles(ctx, reader, nextPaths)
+	}()
+	stopPause := p.watchPause(ctx)
+	defer stopPause()
+	p.infof("starting to wait for results")
+	consume()
+
+	p.end = time.Now()
+	return <-readErr
+}
+
+// maskToken hides token but for its last 4 characters, like ****ab12. The tokens of 8 characters or less are fully
+// hidden. The mask has always the same width so it does not tell the token length.
+func maskToken(token string) string {
+	const visible = 4
+	const mask = "****"
+	runes := []rune(token)
+	if len(runes) <= 2*visible {
+		return mask
+	}
+	return mask + string(runes[len(runes)-visible:])
+}
+
+// logTotals sends the counts of the lines written by the run to the Logger
+func (p *fileProcessor) logTotals() {
+	if p.logEvent(p.countsEvent("summary", p.end.Sub(p.start))) {
+		return
+	}
+	p.logger.Printf("Total: %d", p.totalCounter)
+	p.logger.Printf("Succeded inputs: %d", p.successCounter)
+	p.logger.Printf("Failed: %d", p.failureCounter)
+	if p.validationFailureCounter > 0 {
+		p.logger.Printf("Failed validation: %d", p.validationFailureCounter)
+		p.logger.Printf("Failed processing: %d", p.failureCounter-p.validationFailureCounter)
+	}
+	if p.skippedCounter > 0 {
+		p.logger.Printf("Skipped: %d", p.skippedCounter)
+	}
+	if p.filteredCounter > 0 {
+		p.logger.Printf("Filtered: %d", p.filteredCounter)
+	}
+	if p.writeErrorCounter > 0 {
+		p.logger.Printf("Write errors: %d", p.writeErrorCounter)
+	}
+	if p.config.Deduplicate {
+		p.logger.Printf("Duplicates skipped: %d", p.duplicateCounter)
+	}
+	if p.config.DedupePolicy != KeepDuplicates {
+		p.logger.Printf("Duplicates dropped: %d", p.droppedCounter)
+	}
+	if p.completed != nil {
+		p.logger.Printf("Completed by a previous run: %d", p.completedCounter)
+	}
+	p.logger.Printf("Took %v to run.", p.end.Sub(p.start))
+	for i, line := range p.slowestFirst() {
+		if i == 0 {
+			p.logger.Printf("Slowest lines:")
+		}
+		p.logger.Printf("line %d took %v", line.LineNumber, line.Duration)
+	}
+}
+
+// countsEvent returns the JSONLog message named event with the counts of the lines written so far
+func (p *fileProcessor) countsEvent(event string, elapsed time.Duration) countsEvent {
+	return countsEvent{
+		Event:     event,
+		Processed: p.totalCounter,
+		Success:   p.successCounter,
+		Failure:   p.failureCounter,
+		ElapsedMS: elapsed.Milliseconds(),
+	}
+}
+
+// dryRun reads and validates every line without processing them nor writing any output file
+func (p *fileProcessor) dryRun(ctx context.Context, reader lineReader, paths []string) error {
+	p.start = time.Now()
+	err := p.readFiles(ctx, reader, paths)
+	p.end = time.Now()
+	p.totalCounter = atomic.LoadInt64(&p.readCount)
 
-		var outLine []string
+	p.logger.Printf("Total: %d", p.totalCounter)
+	p.logger.Printf("Valid: %d", p.totalCounter-p.invalidCounter)
+	p.logger.Printf("Invalid: %d", p.invalidCounter)
+	p.logger.Printf("Took %v to run.", p.end.Sub(p.start))
+	return err
+}
+
+// drain returns the channel of the results to write. Once ctx is done the workers get Config.ShutdownTimeout to
+// finish the lines they hold. Past that time the returned channel is closed and the results still to come are
+// discarded. The results are returned as they are when there is no timeout.
+func (p *fileProcessor) drain(ctx context.Context) <-chan result {
+	if p.config.ShutdownTimeout <= 0 {
+		return p.results
+	}
 
-		if record.Output.Success {
-			outLine = append(record.Input.Line)
-			err = successWriter.Write(outLine)
-			if err != nil {
-				_, id := p.processor.GetIdentifier(record.Input)
-				fmt.Println(fmt.Sprintf("error writting item to output with id: %d", id))
+	drained := make(chan result)
+	go func() {
+		defer close(drained)
+		done := ctx.Done()
+		var deadline <-chan time.Time
+		for {
+			select {
+			case record, ok := <-p.results:
+				if !ok {
+					return
+				}
+				drained <- record
+			case <-done:
+				done = nil
+				timer := time.NewTimer(p.config.ShutdownTimeout)
+				defer timer.Stop()
+				deadline = timer.C
+			case <-deadline:
+				// read once drained is closed, which the results loop waits for
+				p.truncated = true
+				// the workers still running must not block on the results
+				go func() {
+					for range p.results {
+					}
+				}()
+				return
 			}
-			successCounter++
-		} else if record.Output.Error != nil {
-			if *showDescription {
-				outLine = append(record.Input.Line, record.Output.Error.Error())
-			} else {
-				outLine = append(record.Input.Line)
+		}
+	}()
+	return drained
+}
+
+// writeConcurrently writes results from Config.WriteConcurrency goroutines, in no particular order
+func (p *fileProcessor) writeConcurrently(results <-chan result) {
+	group := sync.WaitGroup{}
+	group.Add(p.config.WriteConcurrency)
+	for i := 0; i < p.config.WriteConcurrency; i++ {
+		go func() {
+			defer group.Done()
+			for record := range results {
+				p.write(record)
 			}
-			err = failureWriter.Write(outLine)
-			if err != nil {
-				_, id := p.processor.GetIdentifier(record.Input)
-				fmt.Println(fmt.Sprintf("error writting item to output with id: %d", id))
+		}()
+	}
+	group.Wait()
+}
+
+// write writes record to the success or failure file and updates the counters
+func (p *fileProcessor) write(record result) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.inFlight != nil {
+		<-p.inFlight
+	}
+	if record.leftOut {
+		p.markWritten(record)
+		return
+	}
+	atomic.AddInt64(&p.totalCounter, 1)
+	if record.invalid {
+		atomic.AddInt64(&p.invalidCounter, 1)
+	}
+	p.observeDuration(record)
+
+	if record.Output.Success && !record.Output.Skipped && p.config.EnforceColumnCount {
+		record = p.checkColumnCount(record)
+	}
+
+	var outLine []string
+
+	if p.dropDuplicate(record) {
+		atomic.AddInt64(&p.droppedCounter, 1)
+	} else if record.filtered {
+		p.writeFiltered(record)
+	} else if record.Output.Skipped {
+		var err error
+		if p.skippedWriter != nil {
+			err = p.writeLine(p.skippedWriter, record.Input.Line, record.Input.Record)
+		}
+		if err != nil {
+			p.writeFailed(record, fmt.Errorf("error writing line to skipped file: %w", err))
+		} else {
+			atomic.AddInt64(&p.skippedCounter, 1)
+		}
+	} else if record.Output.Success {
+		outLine = record.Output.Line
+		if outLine == nil {
+			outLine = record.Input.Line
+		}
+		outLine = p.project(outLine)
+		outRecord := record.Output.Record
+		if outRecord == nil {
+			outRecord = record.Input.Record
+		}
+		if p.config.CombinedOutput {
+			outLine = p.combinedLine(outLine, successStatus, nil)
+			outRecord = p.combinedRecord(outRecord, successStatus, nil)
+		}
+		if err := p.writeLine(p.successWriters[p.shard(record)], outLine, outRecord); err != nil {
+			p.writeFailed(record, fmt.Errorf("error writing line to output file: %w", err))
+		} else {
+			atomic.AddInt64(&p.successCounter, 1)
+			p.metrics.Written(true)
+			p.markCompleted(record.Input)
+			if p.config.OnSuccess != nil {
+				p.config.OnSuccess(record.Input, record.Output)
 			}
-			failureCounter++
 		}
+	} else {
+		if record.Output.Error != nil {
+			p.reportError(record.Input, record.Output.Error)
+		}
+		writer := p.failureWriter
+		var outRecord map[string]interface{}
+		if p.config.CombinedOutput {
+			// the failures go to the output file, along with the successes of the same shard
+			writer = p.successWriters[p.shard(record)]
+			outLine = p.combinedLine(p.project(record.Input.Line), failureStatus, record.Output.Error)
+			outRecord = p.combinedRecord(record.Input.Record, failureStatus, record.Output.Error)
+		} else {
+			outLine, outRecord = p.failureLine(record), p.failureRecord(record)
+		}
+		if err := p.writeLine(writer, outLine, outRecord); err != nil {
+			p.writeFailed(record, fmt.Errorf("error writing line to failures file: %w", err))
+		} else {
+			atomic.AddInt64(&p.failureCounter, 1)
+			if record.invalid {
+				atomic.AddInt64(&p.validationFailureCounter, 1)
+			}
+			p.metrics.Written(false)
+			if p.config.OnFailure != nil {
+				p.config.OnFailure(record.Input, record.Output)
+			}
+		}
+	}
+	p.sendStream(record)
 
-		if count%100 == 0 {
-			successWriter.Flush()
-			failureWriter.Flush()
+	if p.abortErr == nil {
+		if p.abortErr = p.checkFailures(); p.abortErr != nil {
+			p.logger.Printf("%v", p.abortErr)
+			p.abort()
 		}
+	}
+
+	if p.config.LogLevel >= VerboseLog {
+		p.logLine(record)
+	}
 
-		totalCounter++
+	p.markWritten(record)
+	if p.totalCounter%int64(p.config.FlushEvery) == 0 {
+		for _, writer := range p.successWriters {
+			writer.Flush()
+		}
+		p.failureWriter.Flush()
+		if p.skippedWriter != nil {
+			p.skippedWriter.Flush()
+		}
+		if p.writeErrorWriter != nil {
+			p.writeErrorWriter.Flush()
+		}
+		if p.completedWriter != nil {
+			p.completedWriter.Flush()
+		}
+		if err := p.saveCheckpoint(); err != nil {
+			p.logger.Printf("%v", err)
+		}
+	}
 
-		desc, id := p.processor.GetIdentifier(record.Input)
-		fmt.Printf(" %d processed. failure: %t\t%s: %d\n", count, record.Output.Error != nil, desc, id)
+	if every := int64(p.config.ProgressEvery); every > 0 && p.totalCounter%every == 0 {
+		p.logProgress(record)
 	}
+}
 
-	end := time.Now()
-	fmt.Println(fmt.Sprintf("Total: %d", totalCounter))
-	fmt.Println(fmt.Sprintf("Succeded inputs: %d", successCounter))
-	fmt.Println(fmt.Sprintf("Failed: %d", failureCounter))
-	fmt.Printf("Took %v to run.\n", end.Sub(start))
+// checkFailures returns an error when the failed lines exceed Config.MaxFailures or Config.MaxFailureRatio. The ratio
+// is only checked once minFailureRatioLines lines are written, so a few failures at the start do not abort the run.
+func (p *fileProcessor) checkFailures() error {
+	if p.config.MaxFailures > 0 && p.failureCounter > p.config.MaxFailures {
+		return fmt.Errorf("run aborted: %d lines failed, more than the maximum of %d", p.failureCounter,
+			p.config.MaxFailures)
+	}
+	if p.config.MaxFailureRatio > 0 && p.totalCounter >= minFailureRatioLines {
+		ratio := float64(p.failureCounter) / float64(p.totalCounter)
+		if ratio > p.config.MaxFailureRatio {
+			return fmt.Errorf("run aborted: %.1f%% of the %d lines failed, more than the maximum of %.1f%%",
+				100*ratio, p.totalCounter, 100*p.config.MaxFailureRatio)
+		}
+	}
+	return nil
 }
 
-func (p fileProcessor) worker(id int, group *sync.WaitGroup) {
-	fmt.Println("worker ", id, " started")
-	defer func() {
-		group.Done()
-	}()
-	for input := range p.inputs {
-		output := p.processor.Process(input)
+// checkColumnCount turns record into a failure when its output line does not have as many columns as the output
+// header
+func (p *fileProcessor) checkColumnCount(record result) result {
+	if p.outputWidth == 0 {
+		return record
+	}
+	width := len(record.Input.Line)
+	if record.Output.Line != nil {
+		width = len(record.Output.Line)
+	}
+	if width != p.outputWidth {
+		record.Output.Success = false
+		record.Output.Error = fmt.Errorf("output line has %d columns, expected %d", width, p.outputWidth)
+	}
+	return record
+}
 
-		result := result{
-			Input:  input,
-			Output: output,
+// failureLine returns the line written to the failures file for record. With ShowDescription the lines shorter than
+// the header are padded so the description always lands in the error_description column. The description is left
+// empty when the line failed without an error.
+func (p *fileProcessor) failureLine(record result) []string {
+	line := p.project(record.Input.Line)
+	if !p.config.ShowDescription && !p.config.FailureDurations && !p.config.FailureLineNumbers {
+		return line
+	}
+	width := len(line)
+	if p.config.ShowDescription && width < p.headerWidth && p.config.OutputColumns == nil {
+		width = p.headerWidth
+	}
+
+	// the fields go to a fresh slice, the Input Line may share its array with the Line of another result
+	failure := make([]string, 0, p.rowCapacity(width, 3))
+	if p.config.FailureLineNumbers {
+		failure = append(failure, strconv.Itoa(record.Input.LineNumber))
+	}
+	failure = append(failure, line...)
+	for i := len(line); i < width; i++ {
+		failure = append(failure, "")
+	}
+	if p.config.ShowDescription {
+		description := ""
+		if record.Output.Error != nil {
+			description = record.Output.Error.Error()
 		}
-		p.results <- result
+		failure = append(failure, description)
+	}
+	if p.config.FailureDurations {
+		failure = append(failure, formatMillis(record.duration))
+	}
+	return failure
+}
+
+// rowCapacity returns the capacity of a row of width fields followed by extra ones, width being raised to
+// Config.ExpectedColumns so the rows of a wide file without a header are allocated once
+func (p *fileProcessor) rowCapacity(width, extra int) int {
+	if width < p.config.ExpectedColumns {
+		width = p.config.ExpectedColumns
 	}
+	return width + extra
 }
 
-func (p fileProcessor) readFile(reader *csv.Reader) {
-	fmt.Println("start reading file")
-	for {
-		line, err := reader.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatal(err)
+// transformHeader returns header as changed by Config.HeaderTransform. The transform gets a copy, header can be the
+// one handed to a HeaderAware Processor.
+func (p *fileProcessor) transformHeader(header []string) []string {
+	if p.config.HeaderTransform == nil {
+		return header
+	}
+	return p.config.HeaderTransform(append([]string(nil), header...))
+}
+
+// project returns the fields of line at the Config.OutputColumns positions, the positions past the end of line are
+// empty. line is returned as is when every column is written.
+func (p *fileProcessor) project(line []string) []string {
+	if p.config.OutputColumns == nil {
+		return line
+	}
+	projected := make([]string, len(p.config.OutputColumns))
+	for i, column := range p.config.OutputColumns {
+		if column >= 0 && column < len(line) {
+			projected[i] = line[column]
 		}
+	}
+	return projected
+}
 
-		err = p.processor.Validate(line)
-		if err != nil {
-			log.Fatalf("error reading Line %v with error %p", line, err)
+// failureRecord returns the JSON object written to the failures file for record. With ShowDescription the error
+// description is added to a copy of the Input Record under the error_description key, and with FailureLineNumbers
+// the line number under the line_number key.
+func (p *fileProcessor) failureRecord(record result) map[string]interface{} {
+	if record.Input.Record == nil || (!p.config.ShowDescription && !p.config.FailureLineNumbers &&
+		!p.config.FailureDurations) {
+		return record.Input.Record
+	}
+	fields := make(map[string]interface{}, 3)
+	if p.config.ShowDescription {
+		description := ""
+		if record.Output.Error != nil {
+			description = record.Output.Error.Error()
 		}
+		fields["error_description"] = description
+	}
+	if p.config.FailureLineNumbers {
+		fields["line_number"] = record.Input.LineNumber
+	}
+	if p.config.FailureDurations {
+		fields["process_ms"] = record.duration.Seconds() * 1000
+	}
+	return withFields(record.Input.Record, fields)
+}
+
+// appendField returns a copy of line with field added at the end. line is never modified, even when it has room for
+// one more field: it can be the header or an Input Line shared with the Processor.
+func appendField(line []string, field string) []string {
+	extended := make([]string, len(line), len(line)+1)
+	copy(extended, line)
+	return append(extended, field)
+}
+
+// prependField returns a copy of line with field added at the start, line is never modified
+func prependField(line []string, field string) []string {
+	extended := make([]string, 1, len(line)+1)
+	extended[0] = field
+	return append(extended, line...)
+}
+
+// withFields returns a copy of record with fields added to it
+func withFields(record, fields map[string]interface{}) map[string]interface{} {
+	extended := make(map[string]interface{}, len(record)+len(fields))
+	for key, value := range record {
+		extended[key] = value
+	}
+	for key, value := range fields {
+		extended[key] = value
+	}
+	return extended
+}
+
+// writeLine writes line, or record, to writer. With Config.WriteConcurrency writeMu is released meanwhile so the other
+// goroutines can write to the other files, writer is then a lockedWriter. Every line marked as written is thus in
+// its writer before the next flush, which holds writeMu.
+func (p *fileProcessor) writeLine(writer lineWriter, line []string, record map[string]interface{}) error {
+	if p.config.WriteConcurrency <= 1 {
+		return writer.Write(line, record)
+	}
+	p.writeMu.Unlock()
+	defer p.writeMu.Lock()
+	return writer.Write(line, record)
+}
+
+// writeFailed counts record as a line that could not be written to its file and reports err. The Input line is
+// written to the write errors file along with err, when there is one.
+func (p *fileProcessor) writeFailed(record result, err error) {
+	id := p.lineID(record)
+	p.logger.Printf("error writting item to output with %s", id)
+	p.reportError(record.Input, err)
+	atomic.AddInt64(&p.writeErrorCounter, 1)
+	if p.writeErrorWriter == nil {
+		return
+	}
+
+	var described map[string]interface{}
+	if record.Input.Record != nil {
+		described = withFields(record.Input.Record, map[string]interface{}{"error_description": err.Error()})
+	}
+	if err := p.writeErrorWriter.Write(appendField(record.Input.Line, err.Error()), described); err != nil {
+		p.logger.Printf("error writing item to write errors file with %s: %v", id, err)
+	}
+}
+
+// lineID describes the line of record in the logs by its identifier, or by its line number when it is invalid since
+// GetIdentifier may not handle a line that failed the validation
+func (p *fileProcessor) lineID(record result) string {
+	if record.invalid {
+		return fmt.Sprintf("line number: %d", record.Input.LineNumber)
+	}
+	_, id := p.processor.GetIdentifier(record.Input)
+	return fmt.Sprintf("id: %d", id)
+}
+
+// reportError hands err to the configured OnError callback, if any
+func (p *fileProcessor) reportError(input Input, err error) {
+	if p.config.OnError != nil {
+		p.config.OnError(input, err)
+	}
+}
+
+// logProgress prints the progress line of the last written record
+func (p *fileProcessor) logProgress(record result) {
+	if p.quiet() {
+		return
+	}
+	if p.logEvent(p.countsEvent("progress", time.Since(p.start))) {
+		return
+	}
+	if record.invalid {
+		p.logger.Printf(" %s processed. invalid line %d: %v", p.progress(), record.Input.LineNumber, record.Output.Error)
+		return
+	}
+	desc, id := p.processor.GetIdentifier(record.Input)
+	if record.filtered {
+		p.logger.Printf(" %s processed. filtered\t%s: %d", p.progress(), desc, id)
+		return
+	}
+	if record.Output.Skipped {
+		p.logger.Printf(" %s processed. skipped\t%s: %d", p.progress(), desc, id)
+		return
+	}
+	p.logger.Printf(" %s processed. failure: %t\t%s: %d", p.progress(), !record.Output.Success, desc, id)
+}
+
+// logLine sends the outcome of record to the Logger along with the worker that processed it, its processing time and
+// its error, for VerboseLog
+func (p *fileProcessor) logLine(record result) {
+	outcome := "success"
+	switch {
+	case record.invalid:
+		outcome = "invalid"
+	case record.filtered:
+		outcome = "filtered"
+	case record.Output.Skipped:
+		outcome = "skipped"
+	case !record.Output.Success:
+		outcome = "failure"
+	}
+	processed := ""
+	if record.workerID > 0 {
+		processed = fmt.Sprintf(" by worker %d in %v", record.workerID, record.duration)
+	}
+	if record.Output.Error != nil {
+		p.logger.Printf("line %d: %s%s: %v", record.Input.LineNumber, outcome, processed, record.Output.Error)
+		return
+	}
+	p.logger.Printf("line %d: %s%s", record.Input.LineNumber, outcome, processed)
+}
+
+// progress returns the number of lines written so far. When the expected total is known it also holds the
+// percentage written and the remaining time estimated from the elapsed time, like 12345/1000000 (1.2%, ETA 2h3m0s).
+func (p *fileProcessor) progress() string {
+	if p.expectedTotal <= 0 {
+		return fmt.Sprintf("%d", p.totalCounter)
+	}
 
-		p.inputs <- Input{Line: line}
+	elapsed := time.Since(p.start)
+	remaining := p.expectedTotal - p.totalCounter
+	if remaining < 0 {
+		remaining = 0
 	}
-	close(p.inputs)
+	eta := time.Duration(float64(elapsed) * float64(remaining) / float64(p.totalCounter))
+	percentage := 100 * float64(p.totalCounter) / float64(p.expectedTotal)
+	return fmt.Sprintf("%d/%d (%.1f%%, ETA %v)", p.totalCounter, p.expectedTotal, percentage, eta.Round(time.Second))
 }
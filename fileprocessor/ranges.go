@@ -0,0 +1,217 @@
+package fileprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// parallelPath returns the input file to be read by byte ranges, or an empty path when the input must be read
+// sequentially. Only a single regular file, neither compressed nor converted from another encoding, can be split, and
+// only when the lines do not need to be read in order.
+func (p *fileProcessor) parallelPath(paths []string) string {
+	cfg := p.config
+	if cfg.ReadConcurrency <= 1 {
+		return ""
+	}
+
+	reason := ""
+	switch {
+	case len(paths) > 1:
+		reason = "there are several input files"
+	case paths[0] == stdStream:
+		reason = "it is the standard input"
+	case isCompressed(paths[0], cfg.Compressed):
+		reason = "it is compressed"
+	case cfg.Encoding != nil:
+		reason = "it is converted from another encoding"
+	case cfg.SkipRows > 0:
+		reason = "its leading rows are skipped"
+	case cfg.PreserveOrder || cfg.CheckpointPath != "" || cfg.Deduplicate || cfg.MaxRows > 0 || cfg.DryRun:
+		reason = "its lines must be read in order"
+	}
+	if reason == "" {
+		info, err := os.Stat(paths[0])
+		if err != nil || !info.Mode().IsRegular() {
+			reason = "it is not a regular file"
+		}
+	}
+	if reason != "" {
+		p.infof("the input is read sequentially: %s", reason)
+		return ""
+	}
+	return paths[0]
+}
+
+// readRanges splits the input file at path in Config.ReadConcurrency byte ranges and reads them at once, each reader
+// sending the lines that start in its range. The lines are sent to the inputs in no particular order.
+func (p *fileProcessor) readRanges(ctx context.Context, path string) error {
+	defer close(p.inputs)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInputOpen, err)
+	}
+	p.infof("start reading file in %d ranges", p.config.ReadConcurrency)
+
+	starts := splitRange(info.Size(), p.config.ReadConcurrency)
+	linesBefore, err := countRangeLines(path, starts)
+	if err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+
+	// the first failing range stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(starts)-1)
+	for i := 0; i < len(starts)-1; i++ {
+		go func(i int) {
+			err := p.readRange(ctx, path, starts[i], starts[i+1], linesBefore[i])
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}(i)
+	}
+
+	var firstErr error
+	for i := 0; i < len(starts)-1; i++ {
+		err := <-errs
+		if err != nil && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// readRange reads the lines of the file at path that start at or after start and before end. linesBefore is the
+// number of line breaks before start.
+func (p *fileProcessor) readRange(ctx context.Context, path string, start, end int64, linesBefore int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInputOpen, err)
+	}
+	defer file.Close()
+
+	offset := start
+	if start > 0 {
+		// the byte before start tells if start is the beginning of a line
+		offset--
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+	input := bufio.NewReaderSize(file, p.config.ReadBufferSize)
+	firstLine := linesBefore + 1
+
+	if start > 0 {
+		// the line holding start belongs to the previous range, unless it starts at start
+		skipped, err := input.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading input file: %w", err)
+		}
+		offset += int64(len(skipped))
+		if offset > start {
+			firstLine++
+		}
+	} else if bom, _ := input.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		input.Discard(len(utf8BOM))
+		offset += int64(len(utf8BOM))
+	}
+
+	lines := p.parseInput(input, firstLine)
+	if csvLines, ok := lines.(csvReader); ok && p.headerWidth > 0 && !p.config.AllowRaggedRows {
+		// the lines of every range must have as many fields as the header, not as the first line of the range
+		csvLines.FieldsPerRecord = p.headerWidth
+	}
+	reader := rangeReader{reader: lines, base: offset, end: end}
+	if start == 0 && p.config.HasHeader {
+		if _, err := reader.Read(); err != nil && err != io.EOF {
+			return fmt.Errorf("%w: %w", ErrHeaderRead, err)
+		}
+	}
+	return p.readFile(ctx, reader)
+}
+
+// splitRange splits size bytes in up to n ranges of about the same size. It returns the start of every range
+// followed by size.
+func splitRange(size int64, n int) []int64 {
+	if int64(n) > size {
+		n = int(size)
+	}
+	if n < 1 {
+		n = 1
+	}
+	starts := make([]int64, n+1)
+	for i := range starts {
+		starts[i] = size * int64(i) / int64(n)
+	}
+	return starts
+}
+
+// countRangeLines returns the number of line breaks of the file at path before the start of every range. The ranges
+// are counted at once.
+func countRangeLines(path string, starts []int64) ([]int, error) {
+	counts := make([]int, len(starts)-1)
+	errs := make(chan error, len(counts))
+	for i := range counts {
+		go func(i int) {
+			var err error
+			counts[i], err = countRangeBreaks(path, starts[i], starts[i+1])
+			errs <- err
+		}(i)
+	}
+	for range counts {
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+	}
+
+	linesBefore := make([]int, len(counts))
+	for i := 1; i < len(counts); i++ {
+		linesBefore[i] = linesBefore[i-1] + counts[i-1]
+	}
+	return linesBefore, nil
+}
+
+// countRangeBreaks returns the number of line breaks of the file at path between start and end
+func countRangeBreaks(path string, start, end int64) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	breaks := 0
+	section := io.NewSectionReader(file, start, end-start)
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := section.Read(buffer)
+		breaks += bytes.Count(buffer[:n], []byte{'\n'})
+		if err == io.EOF {
+			return breaks, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// rangeReader reads the lines of reader that start before end. base is the offset in the file of the first byte of
+// reader.
+type rangeReader struct {
+	reader offsetReader
+	base   int64
+	end    int64
+}
+
+func (r rangeReader) Read() (Input, error) {
+	if r.base+r.reader.InputOffset() >= r.end {
+		return Input{}, io.EOF
+	}
+	return r.reader.Read()
+}
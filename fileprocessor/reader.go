@@ -0,0 +1,282 @@
+package fileprocessor
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// resolveInputPaths splits the comma separated list of paths and expands the glob patterns found in it
+func resolveInputPaths(inputPath string) ([]string, error) {
+	var paths []string
+	for _, path := range strings.Split(inputPath, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strings.ContainsAny(path, "*?[") {
+			paths = append(paths, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid input path pattern %s: %w", ErrInvalidConfig, path, err)
+		}
+		if len(matches) == 0 {
+			// like a missing input file, so the caller can tell it with errors.Is
+			return nil, fmt.Errorf("%w: no file matches %s: %w", ErrInputOpen, path, fs.ErrNotExist)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: no input file provided", ErrInvalidConfig)
+	}
+	return paths, nil
+}
+
+// countInputLines returns the number of lines to be processed from the files at paths, the skipped rows, the headers
+// and the lines of the run being resumed excluded. It returns 0 when it cannot be known, as for the standard input. Quoted fields
+// holding line breaks and blank lines make it an estimate.
+func (p *fileProcessor) countInputLines(paths []string) int64 {
+	total := 0
+	for _, path := range paths {
+		if path == stdStream {
+			return 0
+		}
+		lines, err := countLines(path, p.config.Compressed)
+		if err != nil {
+			p.logger.Printf("error counting the lines of %s: %v", path, err)
+			return 0
+		}
+		total += lines
+	}
+
+	headers := 1
+	if p.config.HasHeader && p.config.HeaderInEveryFile {
+		headers = len(paths)
+	}
+	total -= headers * p.config.SkipRows
+	if p.config.HasHeader {
+		total -= headers
+	}
+	if p.config.MaxRows > 0 && total > p.config.MaxRows {
+		total = p.config.MaxRows
+	}
+	total -= p.resumeFrom
+	if total < 0 {
+		return 0
+	}
+	return int64(total)
+}
+
+// readFiles reads the first input file through reader and then every file at paths, in order, as if they were a
+// single file. The header of the following files is skipped when Config.HeaderInEveryFile is set.
+func (p *fileProcessor) readFiles(ctx context.Context, reader lineReader, paths []string) error {
+	defer close(p.inputs)
+	p.infof("start reading file")
+	if err := p.readFile(ctx, reader); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if p.maxRowsRead() {
+			break
+		}
+		if err := p.readNextFile(ctx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNextFile opens the input file at path and reads it
+func (p *fileProcessor) readNextFile(ctx context.Context, path string) error {
+	file, err := openInput(path, p.config.Compressed)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInputOpen, err)
+	}
+	defer file.Close()
+
+	p.infof("start reading file %s", path)
+	skipRows := 0
+	if p.config.HasHeader && p.config.HeaderInEveryFile {
+		skipRows = p.config.SkipRows
+	}
+	reader, err := p.newReader(file, skipRows)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrHeaderRead, path, err)
+	}
+	if p.config.HasHeader && p.config.HeaderInEveryFile {
+		if _, err := reader.Read(); err != nil && err != io.EOF {
+			return fmt.Errorf("%w %s: %w", ErrHeaderRead, path, err)
+		}
+	}
+	return p.readFile(ctx, reader)
+}
+
+// readFile sends every line of reader to the inputs, or to the results when it is invalid
+func (p *fileProcessor) readFile(ctx context.Context, reader lineReader) error {
+	for !p.maxRowsRead() {
+		input, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		// a line without the expected number of fields is handled as an invalid line
+		fieldCountErr := err
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return fmt.Errorf("error reading input file: %w", err)
+		}
+
+		line, lineNumber := input.Line, input.LineNumber
+		atomic.AddInt64(&p.readCount, 1)
+
+		err = fieldCountErr
+		if err == nil && len(line) < p.config.MinColumns {
+			err = fmt.Errorf("expected %d columns, got %d", p.config.MinColumns, len(line))
+		}
+		if err == nil {
+			err = p.checkColumnRules(input)
+		}
+		// the lines breaking a column rule go to the failures file, even without SkipInvalid
+		skipInvalid := p.config.SkipInvalid || errors.Is(err, ErrColumnRule)
+		if err == nil {
+			err = p.processor.Validate(line)
+		}
+		if p.config.DryRun {
+			if err != nil {
+				atomic.AddInt64(&p.invalidCounter, 1)
+				p.invalidLines = append(p.invalidLines, LineError{LineNumber: lineNumber, Err: err})
+				p.logger.Printf("invalid line %d: %v", lineNumber, err)
+			}
+			continue
+		}
+		if err != nil {
+			if !skipInvalid {
+				return fmt.Errorf("%w: line %d %v: %w", ErrInvalidInput, lineNumber, line, err)
+			}
+
+			// invalid lines are not processed, they go straight to the failures file
+			if err := p.sendResult(ctx, result{Input: input, Output: Output{Error: err}, invalid: true}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if p.config.Deduplicate && p.isDuplicate(input) {
+			if err := p.leaveOut(ctx, input, &p.duplicateCounter); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.completed != nil && p.isCompleted(input) {
+			if err := p.leaveOut(ctx, input, &p.completedCounter); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.isFiltered(line) {
+			// the filtered lines are not processed, they are counted and written, if at all, by the results loop
+			if err := p.sendResult(ctx, result{Input: input, filtered: true}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.sendInput(ctx, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxRowsRead tells if Config.MaxRows lines were already read
+func (p *fileProcessor) maxRowsRead() bool {
+	return p.config.MaxRows > 0 && atomic.LoadInt64(&p.readCount) >= int64(p.config.MaxRows)
+}
+
+// isDuplicate tells if a line with the same identifier as input was already read
+func (p *fileProcessor) isDuplicate(input Input) bool {
+	_, id := p.processor.GetIdentifier(input)
+	if _, ok := p.seenIdentifiers[id]; ok {
+		return true
+	}
+	p.seenIdentifiers[id] = struct{}{}
+	return false
+}
+
+// sendInput hands input to the workers
+func (p *fileProcessor) sendInput(ctx context.Context, input Input) error {
+	input.index = int(atomic.AddInt64(&p.sentCount, 1) - 1)
+	if input.index < p.resumeFrom {
+		// written by the run being resumed
+		return nil
+	}
+	if err := p.acquireSlot(ctx); err != nil {
+		return err
+	}
+	select {
+	case p.inputs <- input:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquireSlot waits until fewer than Config.MaxInFlight lines are read and not written yet. The slot is released
+// once the line is written.
+func (p *fileProcessor) acquireSlot(ctx context.Context) error {
+	if p.inFlight == nil {
+		return nil
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendResult sends a line that does not need to be processed straight to the results
+func (p *fileProcessor) sendResult(ctx context.Context, record result) error {
+	record.Input.index = int(atomic.AddInt64(&p.sentCount, 1) - 1)
+	if record.Input.index < p.resumeFrom {
+		// written by the run being resumed
+		return nil
+	}
+	return p.pushResult(ctx, record)
+}
+
+// leaveOut counts input in counter and sends it to the results as a line that is neither processed nor written. Like
+// every line read it takes an index, so the checkpoint and Config.PreserveOrder count it and a resumed run skips the
+// same lines as the run it resumes.
+func (p *fileProcessor) leaveOut(ctx context.Context, input Input, counter *int64) error {
+	input.index = int(atomic.AddInt64(&p.sentCount, 1) - 1)
+	if input.index < p.resumeFrom {
+		// left out, or written, by the run being resumed
+		return nil
+	}
+	atomic.AddInt64(counter, 1)
+	return p.pushResult(ctx, result{Input: input, leftOut: true})
+}
+
+// pushResult sends record, whose index is set, to the results once a slot is free
+func (p *fileProcessor) pushResult(ctx context.Context, record result) error {
+	if err := p.acquireSlot(ctx); err != nil {
+		return err
+	}
+	select {
+	case p.results <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+package fileprocessor
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]func() Processor)
+)
+
+// Register makes a Processor available to Main under name, usually from the init function of the package that
+// implements it. factory is called once per run. Register panics when name is empty, factory is nil or name is already
+// registered.
+func Register(name string, factory func() Processor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" || factory == nil {
+		panic("fileprocessor: Register needs a name and a factory")
+	}
+	if _, ok := registry[name]; ok {
+		panic("fileprocessor: Register called twice for processor " + name)
+	}
+	registry[name] = factory
+}
+
+// Processors returns the names of the registered processors, sorted
+func Processors() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Main is like Process but runs the registered Processor named by the -processor argument, so a single program can
+// hold several processors. Any error is fatal.
+func Main() {
+	var name string
+	cfg, err := parseFlags(os.Args[1:], true, &name)
+	exitOnArgsError(err)
+
+	processor, err := newRegistered(name)
+	exitOnArgsError(err)
+	exitOnError(ProcessE(processor, cfg))
+}
+
+// newRegistered returns a new instance of the Processor registered under name
+func newRegistered(name string) (Processor, error) {
+	registryMu.Lock()
+	factory, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown -processor %q, the registered processors are: %s", name,
+			strings.Join(Processors(), ", "))
+	}
+	return factory(), nil
+}
@@ -0,0 +1,194 @@
+package fileprocessor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrColumnRule is wrapped by the error of a line breaking one of the Config.ColumnRules
+var ErrColumnRule = errors.New("column rule violated")
+
+// ColumnType is the type of the values a ColumnRule accepts
+type ColumnType int
+
+const (
+	//TextColumn accepts any value, it is the default
+	TextColumn ColumnType = iota
+	//IntColumn accepts the base 10 integers, such as -12
+	IntColumn
+	//FloatColumn accepts the decimal numbers, such as 3.14 or 1e-3
+	FloatColumn
+)
+
+func (c ColumnType) String() string {
+	switch c {
+	case TextColumn:
+		return "text"
+	case IntColumn:
+		return "int"
+	case FloatColumn:
+		return "float"
+	}
+	return fmt.Sprintf("ColumnType(%d)", int(c))
+}
+
+// ColumnRule is a check on the value of a column of every input line, declared instead of coded in Validate. The Type
+// and the Pattern only check the values that are not empty, so an optional column may be left empty.
+type ColumnRule struct {
+	//Required rejects an empty value, or a missing column
+	Required bool
+	//Type rejects a value that does not parse as that type
+	Type ColumnType
+	//Pattern rejects a value that it does not match, nil when any value is accepted
+	Pattern *regexp.Regexp
+}
+
+// check returns why value breaks the rule, nil when it does not
+func (r ColumnRule) check(value string) error {
+	if value == "" {
+		if r.Required {
+			return errors.New("a value is required")
+		}
+		return nil
+	}
+	switch r.Type {
+	case IntColumn:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+	case FloatColumn:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+	}
+	if r.Pattern != nil && !r.Pattern.MatchString(value) {
+		return fmt.Errorf("%q does not match %s", value, r.Pattern)
+	}
+	return nil
+}
+
+// columnRule is a ColumnRule along with the column it checks
+type columnRule struct {
+	ColumnRule
+	//column is the key of the rule in Config.ColumnRules, a name or a position
+	column string
+	//index is the position of the column in a csv line, -1 for a JSON Lines key
+	index int
+}
+
+// resolveColumnRules finds the position of the column of every Config.ColumnRules in header, the normalized input
+// header, nil when there is none. A key that is not a column name is a position from 0. The keys of a JSON Lines input
+// are looked up in the Record of every line instead.
+func (p *fileProcessor) resolveColumnRules(header []string) error {
+	p.columnRules = nil
+	for column, rule := range p.config.ColumnRules {
+		resolved := columnRule{ColumnRule: rule, column: column, index: -1}
+		if p.config.Format == CSV {
+			resolved.index = columnIndex(header, column)
+			if resolved.index < 0 {
+				return fmt.Errorf("%w: no column %q in the header for the column rule", ErrInvalidConfig, column)
+			}
+		}
+		p.columnRules = append(p.columnRules, resolved)
+	}
+	// the rules are checked in the order of the columns, so the error of a line does not change from one run to another
+	sort.Slice(p.columnRules, func(i, j int) bool {
+		if p.columnRules[i].index != p.columnRules[j].index {
+			return p.columnRules[i].index < p.columnRules[j].index
+		}
+		return p.columnRules[i].column < p.columnRules[j].column
+	})
+	return nil
+}
+
+// columnIndex returns the position of column in header, or the position column stands for, -1 when it is neither
+func columnIndex(header []string, column string) int {
+	for i, name := range header {
+		if name == column {
+			return i
+		}
+	}
+	if index, err := strconv.Atoi(column); err == nil && index >= 0 {
+		return index
+	}
+	return -1
+}
+
+// checkColumnRules returns an error wrapping ErrColumnRule for the first of the Config.ColumnRules broken by input
+func (p *fileProcessor) checkColumnRules(input Input) error {
+	for _, rule := range p.columnRules {
+		if err := rule.check(rule.value(input)); err != nil {
+			return fmt.Errorf("%w: column %s: %w", ErrColumnRule, rule.column, err)
+		}
+	}
+	return nil
+}
+
+// value returns the value of the column of the rule in input, empty when it is missing
+func (r columnRule) value(input Input) string {
+	if r.index < 0 {
+		switch value := input.Record[r.column].(type) {
+		case nil:
+			return ""
+		case string:
+			return value
+		default:
+			return fmt.Sprint(value)
+		}
+	}
+	if r.index < len(input.Line) {
+		return input.Line[r.index]
+	}
+	return ""
+}
+
+// columnRulesFlag collects the column=rules arguments of the repeated columnRule argument
+type columnRulesFlag map[string]ColumnRule
+
+func (c columnRulesFlag) String() string {
+	columns := make([]string, 0, len(c))
+	for column := range c {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return strings.Join(columns, ",")
+}
+
+// Set parses column=rules, the rules being a comma separated list of required, int, float and regex:pattern. The
+// pattern is the rest of the argument, commas included, so it comes last.
+func (c columnRulesFlag) Set(value string) error {
+	column, rules, found := strings.Cut(value, "=")
+	if !found || column == "" || rules == "" {
+		return fmt.Errorf("invalid -columnRule argument %q, it must be column=rules", value)
+	}
+	rule := c[column]
+	for rules != "" {
+		if pattern, ok := strings.CutPrefix(rules, "regex:"); ok {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid -columnRule argument %q: %w", value, err)
+			}
+			rule.Pattern = compiled
+			break
+		}
+		var name string
+		name, rules, _ = strings.Cut(rules, ",")
+		switch strings.TrimSpace(name) {
+		case "required":
+			rule.Required = true
+		case IntColumn.String():
+			rule.Type = IntColumn
+		case FloatColumn.String():
+			rule.Type = FloatColumn
+		default:
+			return fmt.Errorf("invalid -columnRule argument %q, its rules must be required, int, float or regex:pattern",
+				value)
+		}
+	}
+	c[column] = rule
+	return nil
+}
@@ -0,0 +1,98 @@
+package fileprocessor
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Run is a run started in the background by Start
+type Run struct {
+	processor *fileProcessor
+	start     time.Time
+	cancel    context.CancelFunc
+	//done is closed once the run is over, summary and err are set before
+	done    chan struct{}
+	summary Summary
+	err     error
+}
+
+// Start is like ProcessContext but returns as soon as the run is started, the processing going on in the background.
+// The returned Run tells the progress of the processing and waits for its end.
+func Start(ctx context.Context, processor Processor, cfg Config) *Run {
+	ctx, cancel := context.WithCancel(ctx)
+	run := &Run{start: time.Now(), cancel: cancel, done: make(chan struct{})}
+	fProcessor, err := newFileProcessor(processor, cfg)
+	if err != nil {
+		cancel()
+		run.err = err
+		close(run.done)
+		return run
+	}
+
+	run.processor = fProcessor
+	go func() {
+		defer close(run.done)
+		defer cancel()
+		run.summary, run.err = fProcessor.finish(fProcessor.limitDuration(ctx, fProcessor.run))
+	}()
+	return run
+}
+
+// Stats returns the Summary of the lines written so far. While the run goes on only the counts and the Duration are
+// set, the Workers, latencies and invalid lines are known once it is over.
+func (r *Run) Stats() Summary {
+	select {
+	case <-r.done:
+		return r.summary
+	default:
+	}
+	p := r.processor
+	summary := Summary{
+		Total:       atomic.LoadInt64(&p.totalCounter),
+		Success:     atomic.LoadInt64(&p.successCounter),
+		Failure:     atomic.LoadInt64(&p.failureCounter),
+		Skipped:     atomic.LoadInt64(&p.skippedCounter),
+		Filtered:    atomic.LoadInt64(&p.filteredCounter),
+		Dropped:     atomic.LoadInt64(&p.droppedCounter),
+		WriteErrors: atomic.LoadInt64(&p.writeErrorCounter),
+		Invalid:     atomic.LoadInt64(&p.invalidCounter),
+		Duplicates:  atomic.LoadInt64(&p.duplicateCounter),
+		Completed:   atomic.LoadInt64(&p.completedCounter),
+		Duration:    time.Since(r.start),
+	}
+	summary.ValidationFailures = atomic.LoadInt64(&p.validationFailureCounter)
+	summary.ProcessFailures = summary.Failure - summary.ValidationFailures
+	return summary
+}
+
+// Wait waits for the end of the run and returns its error, the one ProcessContext would return
+func (r *Run) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Done returns a channel closed once the run is over
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stop stops the run like a cancelled context would, the lines already processed being written. Wait returns once
+// they are flushed.
+func (r *Run) Stop() {
+	r.cancel()
+}
+
+// Pause holds the workers back until Resume is called, as Config.Pause does
+func (r *Run) Pause() {
+	if r.processor != nil {
+		r.processor.setPaused(true)
+	}
+}
+
+// Resume lets the workers of a paused run take new lines again
+func (r *Run) Resume() {
+	if r.processor != nil {
+		r.processor.setPaused(false)
+	}
+}
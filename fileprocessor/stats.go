@@ -0,0 +1,80 @@
+package fileprocessor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// liveStats are the counts of a run while it goes, as served by the /stats endpoint of Config.MetricsAddr
+type liveStats struct {
+	Total              int64   `json:"total"`
+	Success            int64   `json:"success"`
+	Failure            int64   `json:"failure"`
+	ValidationFailures int64   `json:"validation_failures"`
+	ProcessFailures    int64   `json:"process_failures"`
+	Skipped            int64   `json:"skipped"`
+	Invalid            int64   `json:"invalid"`
+	Duplicates         int64   `json:"duplicates"`
+	Completed          int64   `json:"completed"`
+	Filtered           int64   `json:"filtered"`
+	Dropped            int64   `json:"dropped"`
+	WriteErrors        int64   `json:"write_errors"`
+	Elapsed            string  `json:"elapsed"`
+	LinesPerSecond     float64 `json:"lines_per_second"`
+}
+
+// serveStats starts the HTTP server of Config.MetricsAddr, when it is set, and returns the function stopping it
+func (p *fileProcessor) serveStats() (func(), error) {
+	if p.config.MetricsAddr == "" {
+		return func() {}, nil
+	}
+	listener, err := net.Listen("tcp", p.config.MetricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on the metrics address: %w", err)
+	}
+
+	start := time.Now()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.liveStats(time.Since(start)))
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.logger.Printf("stats server stopped: %v", err)
+		}
+	}()
+	p.infof("serving the stats on http://%s/stats", listener.Addr())
+	return func() {
+		server.Close()
+	}, nil
+}
+
+// liveStats reads the counters of the run, elapsed since its start
+func (p *fileProcessor) liveStats(elapsed time.Duration) liveStats {
+	stats := liveStats{
+		Total:       atomic.LoadInt64(&p.totalCounter),
+		Success:     atomic.LoadInt64(&p.successCounter),
+		Failure:     atomic.LoadInt64(&p.failureCounter),
+		Skipped:     atomic.LoadInt64(&p.skippedCounter),
+		Invalid:     atomic.LoadInt64(&p.invalidCounter),
+		Duplicates:  atomic.LoadInt64(&p.duplicateCounter),
+		Completed:   atomic.LoadInt64(&p.completedCounter),
+		Filtered:    atomic.LoadInt64(&p.filteredCounter),
+		Dropped:     atomic.LoadInt64(&p.droppedCounter),
+		WriteErrors: atomic.LoadInt64(&p.writeErrorCounter),
+		Elapsed:     elapsed.Round(time.Millisecond).String(),
+	}
+	stats.ValidationFailures = atomic.LoadInt64(&p.validationFailureCounter)
+	stats.ProcessFailures = stats.Failure - stats.ValidationFailures
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		stats.LinesPerSecond = float64(stats.Total) / seconds
+	}
+	return stats
+}
@@ -0,0 +1,119 @@
+package fileprocessor
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the Output of an Input processed by ProcessStream
+type Result struct {
+	Input  Input
+	Output Output
+}
+
+// ProcessStream processes the lines received from in with the worker pool and sends the Result of every line on the
+// returned channel, which is closed once in is closed and every line is written, or once the run stops early. The
+// Config works as for ProcessSlice: the lines that fail Validate are sent with their error, as with SkipInvalid, and
+// the lines rejected by the Filter of the Processor are not sent at all. The results are sent in the order the lines
+// were received with Config.PreserveOrder. The returned channel must be drained, the workers wait for it otherwise.
+// wait returns the error that stopped the run, such as a failed health check or too many failures, nil when every
+// line was processed: it waits for the end of the run, so it is called once the results are drained. The lines still
+// sent to in after the run stopped are discarded until in is closed. The LineNumber of an Input received without one
+// is its position in in, starting at 1.
+func ProcessStream(processor Processor, in <-chan Input, cfg Config) (results <-chan Result, wait func() error) {
+	cfg.DryRun = false
+	cfg.CheckpointPath = ""
+	cfg.SummaryPath = ""
+	cfg.SkipInvalid = true
+	out := make(chan Result, cfg.withDefaults().ResultBuffer)
+	done := make(chan struct{})
+	var runErr error
+	go func() {
+		defer close(done)
+		defer close(out)
+		// the producer must never be left blocked on in, whatever stopped the run
+		defer func() {
+			go drain(in)
+		}()
+		fProcessor, err := newFileProcessor(processor, cfg)
+		if err != nil {
+			runErr = err
+			return
+		}
+		fProcessor.stream = out
+		_, runErr = fProcessor.finish(fProcessor.processStream(in))
+	}()
+	return out, func() error {
+		<-done
+		return runErr
+	}
+}
+
+// drain receives from in until it is closed
+func drain(in <-chan Input) {
+	for range in {
+	}
+}
+
+// processStream processes the lines received from in, the results being sent to p.stream by write
+func (p *fileProcessor) processStream(in <-chan Input) error {
+	if err := p.configure(); err != nil {
+		return err
+	}
+	if err := p.resolveColumnRules(nil); err != nil {
+		return err
+	}
+	p.successWriters = []lineWriter{p.newWriter(io.Discard)}
+	p.failureWriter = p.newWriter(io.Discard)
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	p.abort = abort
+
+	readErr := p.runPool(ctx, &channelReader{inputs: in, done: ctx.Done()}, nil, func() {
+		if p.config.PreserveOrder {
+			p.writeOrdered(p.results)
+			return
+		}
+		for record := range p.results {
+			p.write(record)
+		}
+	})
+	if p.abortErr != nil {
+		return p.abortErr
+	}
+	return readErr
+}
+
+// channelReader reads the lines received by ProcessStream until their channel is closed, or done is
+type channelReader struct {
+	inputs <-chan Input
+	done   <-chan struct{}
+	read   int
+}
+
+func (r *channelReader) Read() (Input, error) {
+	var input Input
+	var ok bool
+	select {
+	case input, ok = <-r.inputs:
+	case <-r.done:
+		return Input{}, context.Canceled
+	}
+	if !ok {
+		return Input{}, io.EOF
+	}
+	r.read++
+	if input.LineNumber == 0 {
+		input.LineNumber = r.read
+	}
+	return input, nil
+}
+
+// sendStream sends the Result of record to the channel of ProcessStream, if any
+func (p *fileProcessor) sendStream(record result) {
+	if p.stream == nil || record.filtered {
+		return
+	}
+	p.stream <- Result{Input: record.Input, Output: record.Output}
+}
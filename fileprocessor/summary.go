@@ -0,0 +1,177 @@
+package fileprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Summary holds the counters of a processing run
+type Summary struct {
+	//Total is the number of lines processed
+	Total int64 `json:"total"`
+	//Success is the number of lines written to the output file
+	Success int64 `json:"success"`
+	//Failure is the number of lines written to the failures file
+	Failure int64 `json:"failure"`
+	//ValidationFailures and ProcessFailures split the Failure count by stage: the invalid lines, written to the
+	//failures file with Config.SkipInvalid, and the lines that failed in Process. The lines that failed to be written
+	//are the WriteErrors
+	ValidationFailures int64 `json:"validation_failures"`
+	ProcessFailures    int64 `json:"process_failures"`
+	//Skipped is the number of lines whose Output is Skipped. Success, Failure, Skipped, Filtered, Dropped and
+	//WriteErrors add up to Total
+	Skipped int64 `json:"skipped"`
+	//Filtered is the number of lines rejected by the Filter of the Processor, they are written to the output file
+	//with PassFiltered
+	Filtered int64 `json:"filtered"`
+	//Dropped is the number of lines left out by the Config.DedupePolicy, they are in no file
+	Dropped int64 `json:"dropped"`
+	//WriteErrors is the number of lines that could not be written to their file, they are neither a success nor a
+	//failure
+	WriteErrors int64 `json:"write_errors"`
+	//Invalid is the number of lines that did not pass the validation. They are part of the Failure count unless
+	//the run is a dry run, where no line is processed.
+	Invalid int64 `json:"invalid"`
+	//Duplicates is the number of lines skipped because their identifier was already read, when deduplicating
+	Duplicates int64 `json:"duplicates"`
+	//Completed is the number of lines skipped because their identifier is in the Config.CompletedPath file
+	Completed int64 `json:"completed"`
+	//Unprocessed is the number of input lines left when the run stopped early, such as after Config.MaxDuration. It
+	//is only known when the input files are counted beforehand, with ProgressEvery or MaxDuration, which is not done
+	//for the standard input nor by ProcessReader
+	Unprocessed int64 `json:"unprocessed"`
+	//Truncated indicates that the run stopped after Config.ShutdownTimeout without waiting for every worker. The lines
+	//they held are in no count and the Workers and latencies are left out
+	Truncated bool `json:"truncated"`
+	//Duration is the time the processing took, in nanoseconds when written as json
+	Duration time.Duration `json:"duration"`
+	//MinLatency, MaxLatency and AvgLatency are the shortest, longest and average time spent processing a line
+	MinLatency time.Duration `json:"min_latency"`
+	MaxLatency time.Duration `json:"max_latency"`
+	AvgLatency time.Duration `json:"avg_latency"`
+	//Workers holds the activity of each worker
+	Workers []WorkerSummary `json:"workers,omitempty"`
+	//Slowest holds the Config.SlowestLines slowest lines processed, the slowest one first
+	Slowest []LineDuration `json:"slowest,omitempty"`
+	//InvalidLines holds the validation error of every invalid line on a dry run, in the input order
+	InvalidLines []LineError `json:"invalid_lines,omitempty"`
+}
+
+// LineError is the error found on a line of the input file
+type LineError struct {
+	//LineNumber is the line of the input file where the failed Line starts
+	LineNumber int
+	Err        error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.LineNumber, e.Err)
+}
+
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON writes the error description, the error itself cannot be encoded
+func (e LineError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		LineNumber int    `json:"line_number"`
+		Error      string `json:"error"`
+	}{e.LineNumber, e.Err.Error()})
+}
+
+// WorkerSummary holds the activity of a single worker. The latencies of a batch are split evenly among its lines.
+type WorkerSummary struct {
+	//ID identifies the worker, from 1 to Config.Threads
+	ID int `json:"id"`
+	//Processed is the number of lines processed by the worker
+	Processed int64 `json:"processed"`
+	//ProcessTime is the time the worker spent inside Process or ProcessBatch, retries included
+	ProcessTime time.Duration `json:"process_time"`
+	//MinLatency and MaxLatency are the shortest and longest time spent processing a line
+	MinLatency time.Duration `json:"min_latency"`
+	MaxLatency time.Duration `json:"max_latency"`
+}
+
+// observe records a call that processed the given number of lines in d
+func (w *WorkerSummary) observe(d time.Duration, lines int) {
+	w.ProcessTime += d
+	latency := d / time.Duration(lines)
+	if w.MinLatency == 0 || latency < w.MinLatency {
+		w.MinLatency = latency
+	}
+	if latency > w.MaxLatency {
+		w.MaxLatency = latency
+	}
+}
+
+// summary returns the Summary of the lines written so far
+func (p *fileProcessor) summary() Summary {
+	end := p.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	summary := Summary{
+		Total:       p.totalCounter,
+		Success:     p.successCounter,
+		Failure:     p.failureCounter,
+		Skipped:     p.skippedCounter,
+		Filtered:    p.filteredCounter,
+		Dropped:     p.droppedCounter,
+		WriteErrors: p.writeErrorCounter,
+		Invalid:     p.invalidCounter,
+		Duplicates:  p.duplicateCounter,
+		Completed:   p.completedCounter,
+		Duration:    end.Sub(p.start),
+
+		InvalidLines: p.invalidLines,
+	}
+
+	summary.ValidationFailures = p.validationFailureCounter
+	summary.ProcessFailures = p.failureCounter - p.validationFailureCounter
+	summary.Slowest = p.slowestFirst()
+	summary.Truncated = p.truncated
+	if p.truncated {
+		// some workers are still running
+		return summary
+	}
+
+	var processed int64
+	var processTime time.Duration
+	for _, worker := range p.workerStats {
+		if worker.ID == 0 {
+			// the worker never started
+			continue
+		}
+		summary.Workers = append(summary.Workers, worker)
+		if worker.Processed == 0 {
+			continue
+		}
+		processed += worker.Processed
+		processTime += worker.ProcessTime
+		if summary.MinLatency == 0 || worker.MinLatency < summary.MinLatency {
+			summary.MinLatency = worker.MinLatency
+		}
+		if worker.MaxLatency > summary.MaxLatency {
+			summary.MaxLatency = worker.MaxLatency
+		}
+	}
+	if processed > 0 {
+		summary.AvgLatency = processTime / time.Duration(processed)
+	}
+	return summary
+}
+
+// writeSummary writes summary as json to the file at path
+func writeSummary(path string, summary Summary) error {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("error writing summary file: %w", err)
+	}
+	return nil
+}
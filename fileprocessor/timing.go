@@ -0,0 +1,67 @@
+package fileprocessor
+
+import (
+	"container/heap"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LineDuration is the time spent processing a line of the input file, its retries included. The lines of a batch
+// share the time of the whole batch.
+type LineDuration struct {
+	//LineNumber is the line of the input file where the Line starts
+	LineNumber int `json:"line_number"`
+	//Duration is in nanoseconds when written as json
+	Duration time.Duration `json:"duration"`
+}
+
+// slowestLines is a min-heap of the slowest lines processed so far, the fastest of them first
+type slowestLines []LineDuration
+
+func (s slowestLines) Len() int           { return len(s) }
+func (s slowestLines) Less(i, j int) bool { return s[i].Duration < s[j].Duration }
+func (s slowestLines) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *slowestLines) Push(x interface{}) {
+	*s = append(*s, x.(LineDuration))
+}
+
+func (s *slowestLines) Pop() interface{} {
+	old := *s
+	last := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return last
+}
+
+// observeDuration keeps record among the Config.SlowestLines slowest processed lines when it is slower than one of
+// them. The invalid and filtered lines are not processed.
+func (p *fileProcessor) observeDuration(record result) {
+	if p.config.SlowestLines <= 0 || record.invalid || record.filtered {
+		return
+	}
+	line := LineDuration{LineNumber: record.Input.LineNumber, Duration: record.duration}
+	if len(p.slowest) < p.config.SlowestLines {
+		heap.Push(&p.slowest, line)
+	} else if line.Duration > p.slowest[0].Duration {
+		p.slowest[0] = line
+		heap.Fix(&p.slowest, 0)
+	}
+}
+
+// slowestFirst returns the slowest lines processed, the slowest one first
+func (p *fileProcessor) slowestFirst() []LineDuration {
+	if len(p.slowest) == 0 {
+		return nil
+	}
+	lines := append([]LineDuration(nil), p.slowest...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Duration > lines[j].Duration
+	})
+	return lines
+}
+
+// formatMillis returns d in milliseconds, with up to microsecond precision
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,39 @@
+package fileprocessor
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+)
+
+// Version is the version printed by the -version argument and in the startup messages. It can be set when building
+// the program with -ldflags "-X github.com/tfregonese/go-utilities/fileprocessor.Version=v1.2.3". When it is empty
+// the version is read from the build information of the program.
+var Version string
+
+// versionInfo returns Version, or the version of the program and of this package as recorded in the build
+// information, along with the VCS revision it was built from
+func versionInfo() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Path + " " + info.Main.Version
+	pkgPath := reflect.TypeOf(fileProcessor{}).PkgPath()
+	for _, dep := range info.Deps {
+		if strings.HasPrefix(pkgPath, dep.Path) {
+			version += fmt.Sprintf(", %s %s", dep.Path, dep.Version)
+		}
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += ", revision " + setting.Value
+		}
+	}
+	return version
+}
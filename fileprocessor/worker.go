@@ -0,0 +1,360 @@
+package fileprocessor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+func (p *fileProcessor) worker(ctx context.Context, id int, group *sync.WaitGroup) {
+	// the worker is part of the group while it waits to start, so the results are not closed before it is done
+	defer func() {
+		group.Done()
+	}()
+	if !p.waitStart(ctx, id) {
+		return
+	}
+	if !p.quiet() && !p.logEvent(workerEvent{Event: "worker_started", WorkerID: id}) {
+		p.logger.Printf("worker %d started", id)
+	}
+
+	// every worker updates only its own stats, they are read once all the workers are done
+	stats := &p.workerStats[id-1]
+	stats.ID = id
+
+	if batchProcessor, ok := p.processor.(BatchProcessor); ok {
+		p.batchWorker(ctx, batchProcessor, stats)
+		return
+	}
+
+	process, release := p.lineProcessor()
+	defer release()
+	for {
+		input, ok := p.nextInput(ctx)
+		if !ok {
+			return
+		}
+
+		start := time.Now()
+		output := p.processLine(ctx, process, input, stats)
+
+		result := result{
+			Input:    input,
+			Output:   output,
+			duration: time.Since(start),
+			workerID: id,
+		}
+		p.results <- result
+	}
+}
+
+// batchWorker gathers up to Config.BatchSize inputs before handing them to the BatchProcessor. A smaller batch is
+// processed when the input is exhausted.
+func (p *fileProcessor) batchWorker(ctx context.Context, batchProcessor BatchProcessor, stats *WorkerSummary) {
+	batch := make([]Input, 0, p.config.BatchSize)
+	for {
+		input, ok := p.nextInput(ctx)
+		if ok {
+			batch = append(batch, input)
+		}
+		if len(batch) == p.config.BatchSize || (!ok && len(batch) > 0) {
+			p.processBatch(ctx, batchProcessor, batch, stats)
+			batch = batch[:0]
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// lineProcessor returns the function processing the lines of a worker: Process, or ProcessWithState with a new state
+// when the Processor is WorkerScoped. release closes the state once the worker is done, when it is an io.Closer.
+// A call given up after Config.ProcessTimeout keeps its state, which the worker replaces by a new one: the state is
+// thus never used by two calls at once, and it is closed once the last call using it returns.
+func (p *fileProcessor) lineProcessor() (process func(Input) Output, release func()) {
+	scoped, ok := p.processor.(WorkerScoped)
+	if !ok {
+		return p.processor.Process, func() {}
+	}
+
+	mu := sync.Mutex{}
+	current := &workerState{state: scoped.NewWorkerState()}
+	process = func(input Input) Output {
+		mu.Lock()
+		if current.calls > 0 {
+			// the worker only calls process once the previous call returned or was given up
+			current.retired = true
+			current = &workerState{state: scoped.NewWorkerState()}
+		}
+		state := current
+		state.calls++
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			state.calls--
+			if state.retired && state.calls == 0 {
+				p.closeState(state.state)
+			}
+		}()
+		return scoped.ProcessWithState(input, state.state)
+	}
+	release = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		current.retired = true
+		if current.calls == 0 {
+			p.closeState(current.state)
+		}
+	}
+	return process, release
+}
+
+// workerState is a WorkerScoped state along with the number of calls using it. A retired state is not given to any
+// new call, it is closed once its calls return.
+type workerState struct {
+	state   interface{}
+	calls   int
+	retired bool
+}
+
+// closeState closes state when it is an io.Closer
+func (p *fileProcessor) closeState(state interface{}) {
+	if closer, ok := state.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			p.logger.Printf("error closing the worker state: %v", err)
+		}
+	}
+}
+
+// processLine processes input through process. The call is retried up to Config.MaxRetries times while it returns a
+// retryable error.
+func (p *fileProcessor) processLine(ctx context.Context, process func(Input) Output, input Input,
+	stats *WorkerSummary) Output {
+	output := p.callProcess(ctx, process, input, stats)
+	for attempt := 0; attempt < p.config.MaxRetries && p.shouldRetry(output); attempt++ {
+		if !p.waitRetry(ctx, attempt) {
+			break
+		}
+		output = p.callProcess(ctx, process, input, stats)
+	}
+	stats.Processed++
+	return output
+}
+
+// callProcess calls process, once the rate limit allows it, and records the time it took in stats
+func (p *fileProcessor) callProcess(ctx context.Context, process func(Input) Output, input Input,
+	stats *WorkerSummary) Output {
+	if err := p.waitRateLimit(ctx); err != nil {
+		return Output{Error: err}
+	}
+
+	start := time.Now()
+	output := p.timedProcess(process, input)
+	elapsed := time.Since(start)
+	stats.observe(elapsed, 1)
+	p.metrics.Processed(1, elapsed)
+	return output
+}
+
+// processBatch processes batch and sends each Input along with its Output to the results. The lines with a
+// retryable error are processed again in a smaller batch up to Config.MaxRetries times.
+func (p *fileProcessor) processBatch(ctx context.Context, batchProcessor BatchProcessor, batch []Input,
+	stats *WorkerSummary) {
+	start := time.Now()
+	outputs := p.callBatch(ctx, batchProcessor, batch, stats)
+	for attempt := 0; attempt < p.config.MaxRetries; attempt++ {
+		var retries []int
+		for i, output := range outputs {
+			if p.shouldRetry(output) {
+				retries = append(retries, i)
+			}
+		}
+		if len(retries) == 0 || !p.waitRetry(ctx, attempt) {
+			break
+		}
+
+		retryBatch := make([]Input, len(retries))
+		for i, index := range retries {
+			retryBatch[i] = batch[index]
+		}
+		for i, output := range p.callBatch(ctx, batchProcessor, retryBatch, stats) {
+			outputs[retries[i]] = output
+		}
+	}
+
+	stats.Processed += int64(len(batch))
+	duration := time.Since(start) / time.Duration(len(batch))
+	for i, input := range batch {
+		p.results <- result{
+			Input:    input,
+			Output:   outputs[i],
+			duration: duration,
+			workerID: stats.ID,
+		}
+	}
+}
+
+// callBatch calls ProcessBatch, once the rate limit allows it, records the time it took in stats and makes sure
+// there is one Output per Input
+func (p *fileProcessor) callBatch(ctx context.Context, batchProcessor BatchProcessor, batch []Input,
+	stats *WorkerSummary) []Output {
+	if err := p.waitRateLimit(ctx); err != nil {
+		return batchError(len(batch), err)
+	}
+
+	start := time.Now()
+	outputs := p.timedBatch(batchProcessor, batch)
+	elapsed := time.Since(start)
+	stats.observe(elapsed, len(batch))
+	p.metrics.Processed(len(batch), elapsed)
+	if len(outputs) == len(batch) {
+		return outputs
+	}
+	return batchError(len(batch), fmt.Errorf("batch returned %d outputs for %d inputs", len(outputs), len(batch)))
+}
+
+// timedProcess calls process and gives up after Config.ProcessTimeout, freeing the worker. A call that times out keeps
+// running in its own goroutine and its Output is discarded.
+func (p *fileProcessor) timedProcess(process func(Input) Output, input Input) Output {
+	if p.config.ProcessTimeout <= 0 {
+		return p.safeProcess(process, input)
+	}
+
+	done := make(chan Output, 1)
+	go func() {
+		done <- p.safeProcess(process, input)
+	}()
+	timer := time.NewTimer(p.config.ProcessTimeout)
+	defer timer.Stop()
+	select {
+	case output := <-done:
+		return output
+	case <-timer.C:
+		return Output{Error: fmt.Errorf("process timed out after %v", p.config.ProcessTimeout)}
+	}
+}
+
+// timedBatch is like timedProcess for ProcessBatch, every Input of batch fails when the call times out
+func (p *fileProcessor) timedBatch(batchProcessor BatchProcessor, batch []Input) []Output {
+	if p.config.ProcessTimeout <= 0 {
+		return p.safeBatch(batchProcessor, batch)
+	}
+
+	done := make(chan []Output, 1)
+	go func() {
+		done <- p.safeBatch(batchProcessor, batch)
+	}()
+	timer := time.NewTimer(p.config.ProcessTimeout)
+	defer timer.Stop()
+	select {
+	case outputs := <-done:
+		return outputs
+	case <-timer.C:
+		return batchError(len(batch), fmt.Errorf("process batch timed out after %v", p.config.ProcessTimeout))
+	}
+}
+
+// safeProcess calls process and turns a panic into a failed Output, so the other lines are still processed and written
+func (p *fileProcessor) safeProcess(process func(Input) Output, input Input) (output Output) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Printf("panic processing line %d: %v\n%s", input.LineNumber, r, debug.Stack())
+			output = Output{Error: fmt.Errorf("process panicked: %v", r)}
+		}
+	}()
+	return process(input)
+}
+
+// safeBatch calls ProcessBatch and turns a panic into a failed Output for every Input of batch
+func (p *fileProcessor) safeBatch(batchProcessor BatchProcessor, batch []Input) (outputs []Output) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Printf("panic processing a batch of %d lines: %v\n%s", len(batch), r, debug.Stack())
+			outputs = batchError(len(batch), fmt.Errorf("process batch panicked: %v", r))
+		}
+	}()
+	return batchProcessor.ProcessBatch(batch)
+}
+
+// batchError returns size failed Outputs holding err
+func batchError(size int, err error) []Output {
+	outputs := make([]Output, size)
+	for i := range outputs {
+		outputs[i] = Output{Error: err}
+	}
+	return outputs
+}
+
+// waitRateLimit blocks until Config.RateLimit allows one more call. It fails when ctx is done first.
+func (p *fileProcessor) waitRateLimit(ctx context.Context) error {
+	if p.limiter == nil {
+		return nil
+	}
+	if err := p.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("line not processed while waiting for the rate limit: %w", err)
+	}
+	return nil
+}
+
+// shouldRetry indicates if output holds an error worth processing its line again
+func (p *fileProcessor) shouldRetry(output Output) bool {
+	if output.Success || output.Skipped || output.Error == nil {
+		return false
+	}
+	return p.config.Retryable == nil || p.config.Retryable(output.Error)
+}
+
+// waitRetry waits before the given retry attempt, doubling Config.RetryBackoff on each attempt. It returns false
+// when ctx is done before the wait is over.
+func (p *fileProcessor) waitRetry(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(p.config.RetryBackoff << attempt)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// nextInput returns the next Input to process. It returns false once the inputs are exhausted or ctx is done.
+func (p *fileProcessor) nextInput(ctx context.Context) (Input, bool) {
+	if !p.pause.wait(ctx) {
+		return Input{}, false
+	}
+	select {
+	case <-ctx.Done():
+		return Input{}, false
+	case input, ok := <-p.inputs:
+		return input, ok
+	}
+}
+
+// waitStart waits for the turn of the worker id within Config.WorkerRampUp. Each worker gets an equal slot of the
+// ramp up and starts at a random time within it. It returns false when ctx is done first.
+func (p *fileProcessor) waitStart(ctx context.Context, id int) bool {
+	rampUp := p.config.WorkerRampUp
+	if rampUp <= 0 {
+		return true
+	}
+	slot := rampUp / time.Duration(p.config.Threads)
+	delay := slot * time.Duration(id-1)
+	if slot > 0 {
+		delay += time.Duration(rand.Int63n(int64(slot)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}